@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net"
 	"time"
@@ -15,10 +16,14 @@ import (
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	// corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"github.com/virtual-kubelet/virtual-kubelet/node"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 
 	// "github.com/virtual-kubelet/virtual-kubelet/log"
@@ -29,8 +34,10 @@ import (
 	"github.com/danopia/kube-pet-node/controllers/caching"
 	"github.com/danopia/kube-pet-node/controllers/firewall"
 	"github.com/danopia/kube-pet-node/controllers/kubeapi"
+	"github.com/danopia/kube-pet-node/controllers/loadbalancer"
 	"github.com/danopia/kube-pet-node/controllers/nodeidentity"
 	"github.com/danopia/kube-pet-node/controllers/pods"
+	"github.com/danopia/kube-pet-node/controllers/staticpods"
 	"github.com/danopia/kube-pet-node/controllers/volumes"
 	// "github.com/danopia/kube-pet-node/pkg/podman"
 )
@@ -43,7 +50,8 @@ type PetNode struct {
 	Kubernetes *kubernetes.Clientset
 
 	// our controllers
-	Firewall *firewall.FirewallController
+	Firewall   *firewall.FirewallController
+	StaticPods *staticpods.StaticPodsController
 	// Pods          *pods.FirewallController
 	// Volumes *volumes.VolumesController
 
@@ -51,6 +59,14 @@ type PetNode struct {
 	NodeRunner *node.NodeController
 	PodRunner  *node.PodController
 
+	// what Shutdown needs to wind things down
+	kubeApi        *kubeapi.KubeApi
+	podProvider    *pods.PodmanProvider
+	nodeRef        *corev1.ObjectReference
+	nodeRunnerDone chan struct{}
+	events         record.EventRecorder
+	eventSink      *flushingEventSink
+
 	// kubernetes object caches
 	// PodInformer       corev1informers.PodInformer
 	// SecretInformer    corev1informers.SecretInformer
@@ -58,7 +74,13 @@ type PetNode struct {
 	// ServiceInformer   corev1informers.ServiceInformer
 }
 
-func NewPetNode(ctx context.Context, nodeName string, podManager *pods.PodManager, kubernetes *kubernetes.Clientset, maxPods int, vpnIface string, nodeIP net.IP, podNets []net.IPNet, cniNet string) (*PetNode, error) {
+func NewPetNode(ctx context.Context, nodeName string, podManager *pods.PodManager, kubernetes *kubernetes.Clientset, dynamicClient dynamic.Interface, maxPods int, vpnIface string, nodeIPs []net.IP, podNets []net.IPNet, cniNet string, manifestPath string, hostExec bool, rootlessNet string, keyType string, apiAuth kubeapi.AuthOptions) (*PetNode, error) {
+
+	// the first address is the one we listen on and register with
+	var nodeIP net.IP
+	if len(nodeIPs) > 0 {
+		nodeIP = nodeIPs[0]
+	}
 
 	autoUpgrade, err := autoupgrade.NewAutoUpgrade()
 	if err != nil {
@@ -75,39 +97,64 @@ func NewPetNode(ctx context.Context, nodeName string, podManager *pods.PodManage
 		return nil, err
 	}
 
-	nodeRunner, err := nodeidentity.NewNodeIdentity(ctx, kubernetes, nodeName, petVersion, conVersion, maxPods, nodeIP, podNets)
-	if err != nil {
-		return nil, err
-	}
-
 	eb := record.NewBroadcaster()
 	eb.StartLogging(func(a string, b ...interface{}) {
 		log.Printf("K8S Event: "+a, b...)
 	})
 	// eb.StartLogging(log.G(context.TODO()).Infof)
-	eb.StartRecordingToSink(&corev1client.EventSinkImpl{Interface: kubernetes.CoreV1().Events("")})
+	// wrapped so that Shutdown can wait for our last events to be sent
+	eventSink := &flushingEventSink{EventSink: &corev1client.EventSinkImpl{Interface: kubernetes.CoreV1().Events("")}}
+	eb.StartRecordingToSink(eventSink)
 
 	kubeletEvents := eb.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "kubelet", Host: nodeName})
-	// Using ObjectReference for events as the node maybe not cached; refer to #42701 for detail.
-	nodeRef := &corev1.ObjectReference{
-		Kind:      "Node",
-		Name:      nodeName,
-		UID:       types.UID(nodeName),
-		Namespace: "",
-	}
 
 	// setup other things... if we have an IP
 	var podInformerFactory kubeinformers.SharedInformerFactory
 	var scmInformerFactory kubeinformers.SharedInformerFactory
+	var dynamicInformerFactory dynamicinformer.DynamicSharedInformerFactory
+	var podProvider *pods.PodmanProvider
 	var podRunner *node.PodController
 	var firewallRunner *firewall.FirewallController
+	var staticPodsRunner *staticpods.StaticPodsController
 	var kubeApiRunner *kubeapi.KubeApi
 
 	if len(nodeIP) > 0 {
 		caching := caching.NewController(kubernetes)
 
-		volumes := volumes.NewVolumesController(kubernetes, caching, podManager.GetPodman())
+		volumes := volumes.NewVolumesController(kubernetes, caching, podManager.GetPodman(), rootlessNet != "")
+		podProvider = pods.NewPodmanProvider(podManager, caching, volumes, kubeletEvents, cniNet, rootlessNet)
+
+		// static pods come from local disk, so get them going before we need the API server
+		if manifestPath != "" {
+			staticPodsRunner = staticpods.NewStaticPodsController(nodeName, manifestPath, kubernetes, podManager, podProvider)
+			go staticPodsRunner.Run(ctx)
+		}
+	}
+
+	// kubelet keeps retrying until it can register, so we do too
+	var nodeRunner *node.NodeController
+	var prevNode *corev1.Node
+	for {
+		prevNode, err = kubernetes.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			prevNode, err = nil, nil
+		}
+		if err == nil {
+			nodeRunner, err = nodeidentity.NewNodeIdentity(ctx, kubernetes, prevNode, nodeName, petVersion, conVersion, maxPods, nodeIP, podNets, rootlessNet != "")
+		}
+		if err == nil {
+			break
+		}
+		log.Println("WARN: Failed to set up our node identity, will retry:", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Second):
+		}
+	}
 
+	if len(nodeIP) > 0 {
 		//https://github.com/virtual-kubelet/virtual-kubelet/blob/3ec3b14e49d0c2f335ca049155d1ee94b2baf35f/cmd/virtual-kubelet/internal/commands/root/root.go
 
 		// Create a shared informer factory for Kubernetes pods in the current namespace (if specified) and scheduled to the current node.
@@ -130,7 +177,7 @@ func NewPetNode(ctx context.Context, nodeName string, podManager *pods.PodManage
 
 		podRunner, err = node.NewPodController(node.PodControllerConfig{
 			PodClient: kubernetes.CoreV1(),
-			Provider:  pods.NewPodmanProvider(podManager, caching, volumes, kubeletEvents, cniNet),
+			Provider:  podProvider,
 
 			PodInformer:       podInformer,
 			EventRecorder:     kubeletEvents,
@@ -142,13 +189,40 @@ func NewPetNode(ctx context.Context, nodeName string, podManager *pods.PodManage
 			return nil, err
 		}
 
-		firewallRunner = firewall.NewFirewallController(nodeName, vpnIface, nodeIP, podNets, serviceInformer, endpointsInformer)
-		go firewallRunner.Run(ctx)
+		// rootless pods have no IPs to route to, and nftables needs root anyway
+		if rootlessNet == "" {
+			dynamicInformerFactory = dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 15*time.Minute)
+			dualStack := firewall.NewDualStackInformers(dynamicInformerFactory, kubernetes.Discovery())
+			firewallRunner = firewall.NewFirewallController(nodeName, vpnIface, nodeIPs, podNets, serviceInformer, endpointsInformer, dualStack)
+			go firewallRunner.Run(ctx)
+			go loadbalancer.NewLoadBalancerController(nodeName, kubernetes, serviceInformer).Run(ctx)
+		} else {
+			log.Println("Not running the firewall because we're rootless")
+		}
 
-		kubeApiRunner, err = kubeapi.NewKubeApi(kubernetes, podManager, nodeName, nodeIP)
+		kubeApiRunner, err = kubeapi.NewKubeApi(kubernetes, kubeletEvents, podManager, nodeName, nodeIP, hostExec, rootlessNet != "", keyType, apiAuth)
 		if err != nil {
 			return nil, err
 		}
+		kubeApiRunner.AddHealthCheck("informers", func(ctx context.Context) error {
+			for name, informer := range map[string]cache.SharedIndexInformer{
+				"pods":       podInformer.Informer(),
+				"secrets":    secretInformer.Informer(),
+				"configmaps": configMapInformer.Informer(),
+				"services":   serviceInformer.Informer(),
+				"endpoints":  endpointsInformer.Informer(),
+			} {
+				if !informer.HasSynced() {
+					return fmt.Errorf("%s informer not synced yet", name)
+				}
+			}
+			return nil
+		})
+		if firewallRunner != nil {
+			kubeApiRunner.AddHealthCheck("firewall", firewallRunner.Healthz)
+			kubeApiRunner.AddDebugHandler("firewall", firewallRunner.ServeDebug)
+		}
+		kubeApiRunner.AddDebugHandler("autoupgrade", autoUpgrade.ServeDebug)
 		go kubeApiRunner.Run(ctx)
 
 		go autoUpgrade.Run(ctx, configMapInformer)
@@ -156,13 +230,19 @@ func NewPetNode(ctx context.Context, nodeName string, podManager *pods.PodManage
 
 	petNode := &PetNode{
 		NodeName:   nodeName,
-		NodeUID:    types.UID(nodeName), // to be replaced
 		Kubernetes: kubernetes,
 		PodManager: podManager,
 
 		NodeRunner: nodeRunner,
 		PodRunner:  podRunner,
 		Firewall:   firewallRunner,
+		StaticPods: staticPodsRunner,
+
+		kubeApi:        kubeApiRunner,
+		podProvider:    podProvider,
+		nodeRunnerDone: make(chan struct{}),
+		events:         kubeletEvents,
+		eventSink:      eventSink,
 
 		// PodInformer:       podInformer,
 		// SecretInformer:    secretInformer,
@@ -170,7 +250,10 @@ func NewPetNode(ctx context.Context, nodeName string, podManager *pods.PodManage
 		// ServiceInformer:   serviceInformer,
 	}
 
-	go nodeRunner.Run(ctx)
+	go func() {
+		defer close(petNode.nodeRunnerDone)
+		nodeRunner.Run(ctx)
+	}()
 	if len(nodeIP) > 0 {
 		go podRunner.Run(ctx, 1) // number of sync workers
 	}
@@ -179,13 +262,30 @@ func NewPetNode(ctx context.Context, nodeName string, podManager *pods.PodManage
 	<-nodeRunner.Ready()
 	log.Println("Node runner ready")
 
-	if node, err := kubernetes.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{}); err == nil {
-		petNode.NodeUID = node.UID
-		nodeRef.UID = node.UID
-	} else {
+	curNode, err := kubernetes.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
 		log.Println("BUG: We didn't see our own node after starting up!")
 		return nil, err
 	}
+	petNode.NodeUID = curNode.ObjectMeta.UID
+	nodeRef := &corev1.ObjectReference{
+		Kind: "Node",
+		Name: nodeName,
+		UID:  curNode.ObjectMeta.UID,
+	}
+	petNode.nodeRef = nodeRef
+
+	kubeletEvents.Eventf(nodeRef, corev1.EventTypeNormal, "Starting" /*StartingKubelet*/, "Starting kube-pet-node.")
+	if prevNode != nil && prevNode.Status.NodeInfo.BootID != "" && prevNode.Status.NodeInfo.BootID != curNode.Status.NodeInfo.BootID {
+		kubeletEvents.Eventf(nodeRef, corev1.EventTypeWarning, "Rebooted", "Node %s has been rebooted, boot id: %s", nodeName, curNode.Status.NodeInfo.BootID)
+	}
+	for _, cond := range curNode.Status.Conditions {
+		if cond.Type == corev1.NodeReady && cond.Status == corev1.ConditionTrue {
+			kubeletEvents.Eventf(nodeRef, corev1.EventTypeNormal, "NodeReady", "Node %s status is now: NodeReady", nodeName)
+		} else if cond.Type == corev1.NodeReady {
+			kubeletEvents.Eventf(nodeRef, corev1.EventTypeNormal, "NodeNotReady", "Node %s status is now: NodeNotReady", nodeName)
+		}
+	}
 
 	if len(nodeIP) == 0 {
 		kubeletEvents.Eventf(nodeRef, corev1.EventTypeWarning, "Configuring", "TODO: Configuring kube-pet networking.")
@@ -200,9 +300,10 @@ func NewPetNode(ctx context.Context, nodeName string, podManager *pods.PodManage
 
 		podInformerFactory.Start(ctx.Done())
 		scmInformerFactory.Start(ctx.Done())
+		if dynamicInformerFactory != nil {
+			dynamicInformerFactory.Start(ctx.Done())
+		}
 		log.Println("Informers started")
-
-		kubeletEvents.Eventf(nodeRef, corev1.EventTypeNormal, "Starting" /*StartingKubelet*/, "Starting kube-pet-node.")
 	}
 
 	return petNode, nil
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+)
+
+// how long we spend on the API server while exiting, on top of any pod grace period
+const shutdownTimeout = 15 * time.Second
+
+// Shutdown winds the node down once the context given to NewPetNode is cancelled:
+// the kubelet API stops, the node goes NotReady, and our last events get sent.
+// With a gracePeriod, pods are also stopped first, because the machine is going down.
+func (pn *PetNode) Shutdown(gracePeriod time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod+shutdownTimeout)
+	defer cancel()
+
+	if pn.kubeApi != nil {
+		select {
+		case <-pn.kubeApi.Done():
+		case <-ctx.Done():
+		}
+	}
+	// the node runner would overwrite our NotReady otherwise
+	select {
+	case <-pn.nodeRunnerDone:
+	case <-ctx.Done():
+	}
+
+	if pn.nodeRef != nil {
+		pn.events.Eventf(pn.nodeRef, corev1.EventTypeNormal, "Shutdown", "Stopping kube-pet-node.")
+		if err := pn.markNotReady(ctx); err != nil {
+			log.Println("WARN: Failed to mark our node NotReady:", err)
+		} else {
+			pn.events.Eventf(pn.nodeRef, corev1.EventTypeNormal, "NodeNotReady", "Node %s status is now: NodeNotReady", pn.NodeName)
+		}
+	}
+
+	if gracePeriod > 0 && pn.podProvider != nil {
+		log.Println("Stopping pods within", gracePeriod, "because the machine is shutting down")
+		pn.podProvider.StopAllPods(ctx, gracePeriod)
+	}
+
+	log.Println("Flushing events...")
+	pn.eventSink.Flush(ctx)
+}
+
+func (pn *PetNode) markNotReady(ctx context.Context) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := pn.Kubernetes.CoreV1().Nodes().Get(ctx, pn.NodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		now := metav1.Now()
+		for idx := range node.Status.Conditions {
+			cond := &node.Status.Conditions[idx]
+			if cond.Type != corev1.NodeReady {
+				continue
+			}
+			if cond.Status != corev1.ConditionFalse {
+				cond.LastTransitionTime = now
+			}
+			cond.Status = corev1.ConditionFalse
+			cond.Reason = "NodeShutdown"
+			cond.Message = "kube-pet-node is shutting down"
+			cond.LastHeartbeatTime = now
+		}
+
+		_, err = pn.Kubernetes.CoreV1().Nodes().UpdateStatus(ctx, node, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// flushingEventSink keeps track of events still on their way to the API server,
+// so that we don't exit before they're sent.
+// The broadcaster is left running, because recorders send to it from their own goroutines
+// and would panic if it was shut down under them.
+type flushingEventSink struct {
+	record.EventSink
+
+	lock       sync.Mutex
+	inFlight   int
+	lastActive time.Time
+}
+
+func (s *flushingEventSink) track() func() {
+	s.lock.Lock()
+	s.inFlight++
+	s.lock.Unlock()
+	return func() {
+		s.lock.Lock()
+		s.inFlight--
+		s.lastActive = time.Now()
+		s.lock.Unlock()
+	}
+}
+
+func (s *flushingEventSink) Create(event *corev1.Event) (*corev1.Event, error) {
+	defer s.track()()
+	return s.EventSink.Create(event)
+}
+
+func (s *flushingEventSink) Update(event *corev1.Event) (*corev1.Event, error) {
+	defer s.track()()
+	return s.EventSink.Update(event)
+}
+
+func (s *flushingEventSink) Patch(event *corev1.Event, data []byte) (*corev1.Event, error) {
+	defer s.track()()
+	return s.EventSink.Patch(event, data)
+}
+
+// Flush waits until the sink has been quiet for a moment.
+// Queued events are sent one after another, so a short quiet spell means there are none left.
+func (s *flushingEventSink) Flush(ctx context.Context) {
+	// the last events might not have reached the sink yet either
+	s.lock.Lock()
+	s.lastActive = time.Now()
+	s.lock.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.lock.Lock()
+		idle := s.inFlight == 0 && time.Since(s.lastActive) > 250*time.Millisecond
+		s.lock.Unlock()
+		if idle {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("WARN: Gave up on sending our last events:", ctx.Err())
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/danopia/kube-pet-node/controllers/pods"
 )
 
 func (pn *PetNode) EnsureStaticPods(ctx context.Context) error {
@@ -20,11 +22,11 @@ func (pn *PetNode) EnsureStaticPods(ctx context.Context) error {
 		return err
 	}
 
-	petPodAPI := pn.Kubernetes.CoreV1().Pods("kube-pets")
+	petPodAPI := pn.Kubernetes.CoreV1().Pods(pods.HostPodNamespace)
 	podSpec := corev1.PodSpec{
 		Containers: []corev1.Container{
 			{
-				Name:  "pet-host",
+				Name:  pods.HostContainerName,
 				Image: "none",
 				Resources: corev1.ResourceRequirements{
 					Requests: corev1.ResourceList{
@@ -55,7 +57,7 @@ func (pn *PetNode) EnsureStaticPods(ctx context.Context) error {
 			Status:             "True",
 		}},
 		ContainerStatuses: []corev1.ContainerStatus{{
-			Name:         "pet-host",
+			Name:         pods.HostContainerName,
 			Image:        "none",
 			Started:      &[]bool{true}[0],
 			Ready:        true,
@@ -70,7 +72,7 @@ func (pn *PetNode) EnsureStaticPods(ctx context.Context) error {
 		StartTime: &[]metav1.Time{metav1.NewTime(bootTime)}[0],
 	}
 
-	if pod, err := petPodAPI.Get(ctx, "host-"+pn.NodeName, metav1.GetOptions{}); err != nil {
+	if pod, err := petPodAPI.Get(ctx, pods.HostPodName(pn.NodeName), metav1.GetOptions{}); err != nil {
 		if !errors.IsNotFound(err) {
 			return err
 		}
@@ -82,13 +84,13 @@ func (pn *PetNode) EnsureStaticPods(ctx context.Context) error {
 			// 	Kind:       "Pod",
 			// },
 			ObjectMeta: metav1.ObjectMeta{
-				Name: "host-" + pn.NodeName,
+				Name: pods.HostPodName(pn.NodeName),
 				Labels: map[string]string{
 					"component": "host",
 					"tier":      "node",
 				},
 				Annotations: map[string]string{
-					"kubernetes.io/config.source": "file",
+					pods.ConfigSourceAnnotation: "file",
 				},
 				OwnerReferences: []metav1.OwnerReference{
 					{
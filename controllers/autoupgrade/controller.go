@@ -3,6 +3,7 @@ package autoupgrade
 import (
 	"context"
 	"log"
+	"sync"
 	"time"
 	// "net"
 	// "net/http"
@@ -20,6 +21,11 @@ type AutoUpgrade struct {
 	IsCapable   bool
 	SelfVersion *semver.Version
 	releaseChan chan *TargetRelease
+
+	// what Run is up to, for the debug endpoint
+	stateLock sync.Mutex
+	state     string
+	target    *TargetRelease
 }
 
 func NewAutoUpgrade() (*AutoUpgrade, error) {
@@ -69,8 +75,10 @@ func (ka *AutoUpgrade) Run(ctx context.Context, cmi corev1informers.ConfigMapInf
 
 	if !ka.IsCapable {
 		log.Println("AutoUpgrade: Leaving disabled for the lifetime of this process.")
+		ka.setState("disabled")
 		return
 	}
+	ka.setState("waiting")
 
 	cmi.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(res interface{}) {
@@ -101,6 +109,8 @@ func (ka *AutoUpgrade) Run(ctx context.Context, cmi corev1informers.ConfigMapInf
 		case targetRelease = <-ka.releaseChan:
 			// Cancel whatever timer we had anytime we get an update
 			timerC = nil
+			ka.setTarget(targetRelease)
+			ka.setState("waiting")
 
 			if targetRelease == nil {
 				log.Println("AutoUpgrade: received empty release info")
@@ -130,12 +140,18 @@ func (ka *AutoUpgrade) Run(ctx context.Context, cmi corev1informers.ConfigMapInf
 
 			// TODO: randomize this over like 60 seconds probably
 			timerC = time.After(5 * time.Second)
+			ka.setState("scheduled")
 
 		case <-timerC:
 			log.Println("AutoUpgrade: NOW INSTALLING", targetRelease.Version)
+			ka.setState("installing")
 
 			if err := targetRelease.ActuallyInstallThisReleaseNow(); err != nil {
 				log.Println("AutoUpgrade: Failed to perform upgrade:", err)
+				ka.setState("failed")
+			} else {
+				// we're probably about to be restarted anyway
+				ka.setState("disabled")
 			}
 			log.Println("AutoUpgrade: Okay, I tried doing a thing, I'm disabling for the rest of this process lifetime.")
 			return
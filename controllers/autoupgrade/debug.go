@@ -0,0 +1,32 @@
+package autoupgrade
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type debugState struct {
+	IsCapable     bool
+	SelfVersion   string
+	State         string
+	TargetRelease *TargetRelease
+}
+
+// ServeDebug shows what the upgrader knows, to see why a node did or didn't upgrade
+func (ka *AutoUpgrade) ServeDebug(w http.ResponseWriter, req *http.Request) {
+	ka.stateLock.Lock()
+	state := debugState{
+		IsCapable:     ka.IsCapable,
+		State:         ka.state,
+		TargetRelease: ka.target,
+	}
+	ka.stateLock.Unlock()
+	if ka.SelfVersion != nil {
+		state.SelfVersion = ka.SelfVersion.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(&state)
+}
@@ -0,0 +1,37 @@
+package autoupgrade
+
+import (
+	"github.com/danopia/kube-pet-node/pkg/metrics"
+)
+
+var (
+	upgradeState  = metrics.NewGaugeVec("kube_pet_autoupgrade_state", "Which state the upgrader is in, 1 for the current state.", "state")
+	upgradeTarget = metrics.NewGaugeVec("kube_pet_autoupgrade_target_info", "The release which the cluster wants us on, if any.", "version")
+)
+
+var upgradeStates = []string{"disabled", "waiting", "scheduled", "installing", "failed"}
+
+func (ka *AutoUpgrade) setState(state string) {
+	ka.stateLock.Lock()
+	ka.state = state
+	ka.stateLock.Unlock()
+
+	for _, knownState := range upgradeStates {
+		if knownState == state {
+			upgradeState.Set(1, knownState)
+		} else {
+			upgradeState.Set(0, knownState)
+		}
+	}
+}
+
+func (ka *AutoUpgrade) setTarget(target *TargetRelease) {
+	ka.stateLock.Lock()
+	ka.target = target
+	ka.stateLock.Unlock()
+
+	upgradeTarget.Reset()
+	if target != nil {
+		upgradeTarget.Set(1, target.Version)
+	}
+}
@@ -3,11 +3,14 @@ package firewall
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"fmt"
 	"hash"
 	"hash/fnv"
 	"log"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
@@ -21,24 +24,33 @@ import (
 type FirewallController struct {
 	NodeName string
 	VpnIface string
-	NodeIP   net.IP
+	NodeIPs  []net.IP
 	PodNets  []net.IPNet
 
 	ServiceInformer   corev1informers.ServiceInformer
 	EndpointsInformer corev1informers.EndpointsInformer
+	DualStack         *DualStackInformers // nil when the cluster doesn't have dual-stack services
 	Debounce          func(func())
 	LatestConfigHash  []byte
+
+	// for health checks and debugging, which come from other goroutines
+	stateLock   sync.Mutex
+	synced      bool
+	syncErr     error
+	lastSync    time.Time
+	lastRuleset string
 }
 
-func NewFirewallController(nodeName string, vpnIface string, nodeIP net.IP, podNets []net.IPNet, si corev1informers.ServiceInformer, ei corev1informers.EndpointsInformer) *FirewallController {
+func NewFirewallController(nodeName string, vpnIface string, nodeIPs []net.IP, podNets []net.IPNet, si corev1informers.ServiceInformer, ei corev1informers.EndpointsInformer, dualStack *DualStackInformers) *FirewallController {
 	return &FirewallController{
 		NodeName: nodeName,
 		VpnIface: vpnIface,
-		NodeIP:   nodeIP,
+		NodeIPs:  nodeIPs,
 		PodNets:  podNets,
 
 		ServiceInformer:   si,
 		EndpointsInformer: ei,
+		DualStack:         dualStack,
 		Debounce:          debounce.New(time.Second),
 	}
 }
@@ -56,6 +68,10 @@ func (hb *HashingBuilder) Write(p []byte) (int, error) {
 // This is run by the debouncer so we don't have any error checking above us
 func (fc *FirewallController) Sync() {
 	// log.Println("Firewall: Starting Sync()")
+	startTime := time.Now()
+	defer func() {
+		firewallSyncDuration.Observe(time.Since(startTime).Seconds())
+	}()
 
 	nft := &nftables.Conn{}
 	writer := &HashingBuilder{
@@ -69,6 +85,9 @@ func (fc *FirewallController) Sync() {
 
 	hash := writer.hasher.Sum(nil)
 	// log.Println("Firewall: config hash", hash)
+	fc.stateLock.Lock()
+	fc.lastRuleset = writer.builder.String()
+	fc.stateLock.Unlock()
 
 	if changed := bytes.Compare(fc.LatestConfigHash, hash) != 0; changed {
 		// log.Println("Firewall: New config:\n", writer.builder.String())
@@ -77,17 +96,56 @@ func (fc *FirewallController) Sync() {
 		if err := nft.Flush(); err != nil {
 			if err.Error() == "Receive: netlink receive: recvmsg: no buffer space available" {
 				log.Println("Firewall: Kernel table updated :) - but we also got an ENOBUFS :(")
-				fc.LatestConfigHash = hash
+				nftablesErrors.Inc("enobufs")
+				fc.appliedConfig(hash)
 			} else {
 				log.Println("Firewall: nftables error:", err)
+				nftablesErrors.Inc("other")
+				firewallSyncs.Inc("failed")
+				fc.setHealth(err)
 			}
 		} else {
 			log.Println("Firewall: Kernel table updated :)")
-			fc.LatestConfigHash = hash
+			fc.appliedConfig(hash)
 		}
 	} else {
 		log.Println("Firewall: Up to date")
+		firewallSyncs.Inc("unchanged")
+		fc.setHealth(nil)
+	}
+}
+
+func (fc *FirewallController) appliedConfig(hash []byte) {
+	fc.stateLock.Lock()
+	fc.LatestConfigHash = hash
+	fc.stateLock.Unlock()
+	firewallSyncs.Inc("applied")
+	firewallConfigHash.Set(float64(binary.BigEndian.Uint32(hash)))
+	firewallLastChange.Set(float64(time.Now().Unix()))
+	fc.setHealth(nil)
+}
+
+func (fc *FirewallController) setHealth(err error) {
+	fc.stateLock.Lock()
+	defer fc.stateLock.Unlock()
+	if err == nil {
+		fc.synced = true
 	}
+	fc.syncErr = err
+	fc.lastSync = time.Now()
+}
+
+// Healthz fails until the first sync lands, and whenever the latest sync failed
+func (fc *FirewallController) Healthz(ctx context.Context) error {
+	fc.stateLock.Lock()
+	defer fc.stateLock.Unlock()
+	if fc.syncErr != nil {
+		return fc.syncErr
+	}
+	if !fc.synced {
+		return fmt.Errorf("not synced yet")
+	}
+	return nil
 }
 
 func (fc *FirewallController) Run(ctx context.Context) {
@@ -97,6 +155,18 @@ func (fc *FirewallController) Run(ctx context.Context) {
 		time.Sleep(2 * time.Second)
 	}
 
+	for !fc.ServiceInformer.Informer().HasSynced() {
+		log.Println("Firewall: Waiting for services sync...")
+		time.Sleep(2 * time.Second)
+	}
+
+	if fc.DualStack != nil {
+		for !fc.DualStack.Services.Informer().HasSynced() || !fc.DualStack.EndpointSlices.Informer().HasSynced() {
+			log.Println("Firewall: Waiting for dual-stack sync...")
+			time.Sleep(2 * time.Second)
+		}
+	}
+
 	log.Println("Firewall: Setting up informer")
 	// fc.EndpointsInformer.Informer().AddEventHandler(func(){})
 	fc.EndpointsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -125,6 +195,36 @@ func (fc *FirewallController) Run(ctx context.Context) {
 		},
 	})
 
+	// external IPs and load balancer ingress are only on the Service
+	fc.ServiceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) {
+			fc.Debounce(fc.Sync)
+		},
+		DeleteFunc: func(interface{}) {
+			fc.Debounce(fc.Sync)
+		},
+		UpdateFunc: func(before, after interface{}) {
+			fc.Debounce(fc.Sync)
+		},
+	})
+
+	// secondary ClusterIPs and their endpoints
+	if fc.DualStack != nil {
+		for _, informer := range []cache.SharedIndexInformer{fc.DualStack.Services.Informer(), fc.DualStack.EndpointSlices.Informer()} {
+			informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+				AddFunc: func(interface{}) {
+					fc.Debounce(fc.Sync)
+				},
+				DeleteFunc: func(interface{}) {
+					fc.Debounce(fc.Sync)
+				},
+				UpdateFunc: func(before, after interface{}) {
+					fc.Debounce(fc.Sync)
+				},
+			})
+		}
+	}
+
 	// log.Println("Firewall: Seeding debounce")
 	fc.Debounce(fc.Sync)
 }
@@ -0,0 +1,27 @@
+package firewall
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ServeDebug prints the ruleset that the latest sync rendered, as an nft script
+func (fc *FirewallController) ServeDebug(w http.ResponseWriter, req *http.Request) {
+	fc.stateLock.Lock()
+	lastSync, syncErr, ruleset, hash := fc.lastSync, fc.syncErr, fc.lastRuleset, fc.LatestConfigHash
+	fc.stateLock.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if lastSync.IsZero() {
+		fmt.Fprintln(w, "# last sync: never")
+	} else {
+		fmt.Fprintln(w, "# last sync:", lastSync.Format(time.RFC3339))
+	}
+	if syncErr != nil {
+		fmt.Fprintln(w, "# last error:", syncErr)
+	}
+	fmt.Fprintf(w, "# config hash: %x\n", hash)
+	fmt.Fprintln(w)
+	fmt.Fprint(w, ruleset)
+}
@@ -0,0 +1,189 @@
+package firewall
+
+import (
+	"log"
+	"net"
+
+	"github.com/google/nftables"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+)
+
+// Our client-go predates dual-stack services, so their secondary family comes from the raw objects:
+// spec.clusterIPs and spec.ipFamilies of each Service, and the EndpointSlices of that family,
+// because Endpoints only ever list the primary family.
+var (
+	servicesResource       = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+	endpointSlicesResource = schema.GroupVersionResource{Group: "discovery.k8s.io", Version: "v1", Resource: "endpointslices"}
+)
+
+// DualStackInformers watch what our typed informers can't see about dual-stack services
+type DualStackInformers struct {
+	Services       informers.GenericInformer
+	EndpointSlices informers.GenericInformer
+}
+
+// NewDualStackInformers gives nil when the cluster has no discovery.k8s.io/v1,
+// which is also too old for dual-stack services
+func NewDualStackInformers(factory dynamicinformer.DynamicSharedInformerFactory, discovery discovery.DiscoveryInterface) *DualStackInformers {
+	if _, err := discovery.ServerResourcesForGroupVersion(endpointSlicesResource.GroupVersion().String()); err != nil {
+		log.Println("Firewall: Only routing primary ClusterIPs, no EndpointSlices:", err)
+		return nil
+	}
+	return &DualStackInformers{
+		Services:       factory.ForResource(servicesResource),
+		EndpointSlices: factory.ForResource(endpointSlicesResource),
+	}
+}
+
+// familyNamed turns spec.ipFamilies and addressType values into our tables
+func familyNamed(name string) (nftables.TableFamily, bool) {
+	switch corev1.IPFamily(name) {
+	case corev1.IPv4Protocol:
+		return nftables.TableFamilyIPv4, true
+	case corev1.IPv6Protocol:
+		return nftables.TableFamilyIPv6, true
+	default:
+		return 0, false
+	}
+}
+
+// ClusterIP finds the service's ClusterIP in the family, going by spec.ipFamilies when it's there
+func (ds *DualStackInformers) ClusterIP(svc *corev1.Service, family nftables.TableFamily) (string, bool) {
+	obj, err := ds.Services.Lister().ByNamespace(svc.ObjectMeta.Namespace).Get(svc.ObjectMeta.Name)
+	if err != nil {
+		return "", false
+	}
+	raw, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return "", false
+	}
+	clusterIPs, _, _ := unstructured.NestedStringSlice(raw.Object, "spec", "clusterIPs")
+	ipFamilies, _, _ := unstructured.NestedStringSlice(raw.Object, "spec", "ipFamilies")
+
+	for idx, clusterIP := range clusterIPs {
+		parsed := net.ParseIP(clusterIP)
+		if parsed == nil {
+			continue
+		}
+		ipFamilyOf := ipFamily(parsed)
+		if idx < len(ipFamilies) {
+			if named, ok := familyNamed(ipFamilies[idx]); ok {
+				ipFamilyOf = named
+			}
+		}
+		if ipFamilyOf == family {
+			return clusterIP, true
+		}
+	}
+	return "", false
+}
+
+// Endpoints gathers the service's EndpointSlices of the family into the shape that buildTable reads,
+// with one subset per slice and only the ready endpoints as addresses
+func (ds *DualStackInformers) Endpoints(svc *corev1.Service, family nftables.TableFamily) (*corev1.Endpoints, bool) {
+	selector := labels.SelectorFromSet(labels.Set{"kubernetes.io/service-name": svc.ObjectMeta.Name})
+	slices, err := ds.EndpointSlices.Lister().ByNamespace(svc.ObjectMeta.Namespace).List(selector)
+	if err != nil || len(slices) == 0 {
+		return nil, false
+	}
+
+	ep := &corev1.Endpoints{}
+	ep.ObjectMeta.Namespace = svc.ObjectMeta.Namespace
+	ep.ObjectMeta.Name = svc.ObjectMeta.Name
+	for _, obj := range slices {
+		raw, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		addressType, _, _ := unstructured.NestedString(raw.Object, "addressType")
+		if sliceFamily, ok := familyNamed(addressType); !ok || sliceFamily != family {
+			continue
+		}
+		ep.Subsets = append(ep.Subsets, sliceSubset(raw))
+	}
+	return ep, true
+}
+
+func sliceSubset(raw *unstructured.Unstructured) corev1.EndpointSubset {
+	var subset corev1.EndpointSubset
+
+	ports, _, _ := unstructured.NestedSlice(raw.Object, "ports")
+	for _, item := range ports {
+		port, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// a slice without a port number has every port, which services never use
+		number, ok, _ := unstructured.NestedInt64(port, "port")
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(port, "name")
+		protocol, _, _ := unstructured.NestedString(port, "protocol")
+		subset.Ports = append(subset.Ports, corev1.EndpointPort{
+			Name:     name,
+			Port:     int32(number),
+			Protocol: corev1.Protocol(protocol),
+		})
+	}
+
+	endpoints, _, _ := unstructured.NestedSlice(raw.Object, "endpoints")
+	for _, item := range endpoints {
+		endpoint, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// unknown readiness counts as ready
+		if ready, ok, _ := unstructured.NestedBool(endpoint, "conditions", "ready"); ok && !ready {
+			continue
+		}
+		var nodeName *string
+		if name, ok, _ := unstructured.NestedString(endpoint, "nodeName"); ok {
+			nodeName = &name
+		}
+		addresses, _, _ := unstructured.NestedStringSlice(endpoint, "addresses")
+		for _, addr := range addresses {
+			subset.Addresses = append(subset.Addresses, corev1.EndpointAddress{IP: addr, NodeName: nodeName})
+		}
+	}
+	return subset
+}
+
+// familyServices picks out the services with a ClusterIP in the family, along with their endpoints.
+// A dual-stack service shows up in its secondary family as a copy with that family's ClusterIP,
+// so that the rest of buildTable doesn't need to know the difference.
+func (fc *FirewallController) familyServices(family nftables.TableFamily, services []*corev1.Service, endpointMap map[string]*corev1.Endpoints) ([]*corev1.Service, map[string]*corev1.Endpoints) {
+	var familyServices []*corev1.Service
+	familyEndpoints := make(map[string]*corev1.Endpoints, len(endpointMap))
+	for _, svc := range services {
+		key := svc.ObjectMeta.Namespace + "/" + svc.ObjectMeta.Name
+		if isInFamily(svc.Spec.ClusterIP, family) {
+			familyServices = append(familyServices, svc)
+			if ep, ok := endpointMap[key]; ok {
+				familyEndpoints[key] = ep
+			}
+			continue
+		}
+		if fc.DualStack == nil {
+			continue
+		}
+
+		clusterIP, ok := fc.DualStack.ClusterIP(svc, family)
+		if !ok {
+			continue
+		}
+		secondary := svc.DeepCopy()
+		secondary.Spec.ClusterIP = clusterIP
+		familyServices = append(familyServices, secondary)
+		if ep, ok := fc.DualStack.Endpoints(svc, family); ok {
+			familyEndpoints[key] = ep
+		}
+	}
+	return familyServices, familyEndpoints
+}
@@ -2,7 +2,9 @@ package firewall
 
 import (
 	"fmt"
+	"net"
 	"sort"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -20,6 +22,190 @@ func (a byNamespaceAndName) Less(i, j int) bool {
 }
 func (a byNamespaceAndName) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 
+// endpointTarget is somewhere that a service sends traffic.
+// Port is zero when only the address is translated, like for ICMP.
+type endpointTarget struct {
+	IP   string
+	Port uint16
+	// whether the endpoint is on this node
+	Local bool
+}
+
+func (et endpointTarget) ChainName(svc *corev1.Service) string {
+	if et.Port == 0 {
+		return fmt.Sprintf("svc-%v-ep-%v", svc.ObjectMeta.UID, et.IP)
+	}
+	return fmt.Sprintf("svc-%v-ep-%v-%v", svc.ObjectMeta.UID, et.IP, et.Port)
+}
+
+func endpointChainNames(svc *corev1.Service, targets []endpointTarget) []string {
+	names := make([]string, len(targets))
+	for idx, target := range targets {
+		names[idx] = target.ChainName(svc)
+	}
+	return names
+}
+
+// the API server doesn't promise an order, and the config hash shouldn't change without reason
+func sortEndpointTargets(targets []endpointTarget) {
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].IP != targets[j].IP {
+			return targets[i].IP < targets[j].IP
+		}
+		return targets[i].Port < targets[j].Port
+	})
+}
+
+// onlyLocalTargets is for externalTrafficPolicy: Local
+func onlyLocalTargets(targets []endpointTarget) []endpointTarget {
+	var local []endpointTarget
+	for _, target := range targets {
+		if target.Local {
+			local = append(local, target)
+		}
+	}
+	return local
+}
+
+// affinityTimeout is how long ClientIP session affinity remembers each client, when the service has it
+func affinityTimeout(svc *corev1.Service) (time.Duration, bool) {
+	if svc.Spec.SessionAffinity != corev1.ServiceAffinityClientIP {
+		return 0, false
+	}
+	seconds := int32(corev1.DefaultClientIPServiceAffinitySeconds)
+	if config := svc.Spec.SessionAffinityConfig; config != nil && config.ClientIP != nil && config.ClientIP.TimeoutSeconds != nil {
+		seconds = *config.ClientIP.TimeoutSeconds
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// newAffinitySet holds the clients which an endpoint's chain has seen lately
+func newAffinitySet(family nftables.TableFamily, chain string, timeout time.Duration) *nftables.Set {
+	keyType := nftables.TypeIPAddr
+	if family == nftables.TableFamilyIPv6 {
+		keyType = nftables.TypeIP6Addr
+	}
+	return &nftables.Set{
+		Name:       "affinity-" + chain,
+		KeyType:    keyType,
+		Dynamic:    true,
+		HasTimeout: true,
+		Timeout:    timeout,
+	}
+}
+
+// addDnatRules sends the packets which match() picks out to one of the targets.
+// With session affinity, clients first go back to whichever endpoint still remembers them,
+// and otherwise get load-balanced like everyone else. That's also what happens when their endpoint goes away,
+// because its chain and set go with it.
+// When there's more than one target, or any affinity, each target's chain has to already exist.
+func addDnatRules(nft *NftWriter, rule *RuleBuilder, svc *corev1.Service, targets []endpointTarget, affinitySets map[string]*nftables.Set, match func(), comment string) {
+	for _, target := range targets {
+		if set, ok := affinitySets[target.ChainName(svc)]; ok {
+			rule.Reset()
+			match()
+			rule.IsIpSrcAddressInSet(set)
+			rule.GoToChain(target.ChainName(svc))
+			nft.AddRuleWithComment(rule, comment+" affinity")
+		}
+	}
+
+	rule.Reset()
+	match()
+	rule.Counter()
+	if len(targets) > 1 {
+		// spread new connections evenly, conntrack keeps existing ones where they are
+		rule.NumgenRandom(uint32(len(targets)))
+		rule.GoToChainByNumber(endpointChainNames(svc, targets))
+	} else if _, ok := affinitySets[targets[0].ChainName(svc)]; ok {
+		// the chain remembers the client for when there's a choice
+		rule.GoToChain(targets[0].ChainName(svc))
+	} else {
+		rule.TranslateDestination(targets[0].IP, targets[0].Port)
+	}
+	nft.AddRuleWithComment(rule, comment)
+}
+
+// externalAddresses are where a service is offered besides its ClusterIP:
+// spec.externalIPs, and load balancer ingress IPs (which can be our own, see the loadbalancer controller)
+func externalAddresses(svc *corev1.Service) []string {
+	var addrs []string
+	seen := make(map[string]bool)
+	add := func(addr string) {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, addr := range svc.Spec.ExternalIPs {
+		add(addr)
+	}
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		add(ingress.IP)
+	}
+	return addrs
+}
+
+// servicePortProtocols lists each protocol which the service has a port for, in order
+func servicePortProtocols(svc *corev1.Service) []corev1.Protocol {
+	var protocols []corev1.Protocol
+	seen := make(map[corev1.Protocol]bool)
+	for _, port := range svc.Spec.Ports {
+		if !seen[port.Protocol] {
+			seen[port.Protocol] = true
+			protocols = append(protocols, port.Protocol)
+		}
+	}
+	return protocols
+}
+
+// nodePortTarget is a service port which is also reachable on our own addresses
+type nodePortTarget struct {
+	Service      *corev1.Service
+	Port         corev1.ServicePort
+	Targets      []endpointTarget
+	AffinitySets map[string]*nftables.Set
+}
+
+// loopbackNet is where NodePorts aren't offered,
+// because packets from there can't be sent anywhere else once they're translated
+func loopbackNet(family nftables.TableFamily) net.IPNet {
+	if family == nftables.TableFamilyIPv6 {
+		return net.IPNet{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)}
+	}
+	return net.IPNet{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}
+}
+
+// ipFamily is which of our tables an address belongs in
+func ipFamily(ip net.IP) nftables.TableFamily {
+	if ip.To4() == nil {
+		return nftables.TableFamilyIPv6
+	}
+	return nftables.TableFamilyIPv4
+}
+
+func isInFamily(ip string, family nftables.TableFamily) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && ipFamily(parsed) == family
+}
+
+// Families gives the address families which we need a table for.
+// IPv4 is always there, IPv6 only when this node has it.
+func (fc *FirewallController) Families() []nftables.TableFamily {
+	families := []nftables.TableFamily{nftables.TableFamilyIPv4}
+	for _, ip := range fc.NodeIPs {
+		if ipFamily(ip) == nftables.TableFamilyIPv6 {
+			return append(families, nftables.TableFamilyIPv6)
+		}
+	}
+	for _, podNet := range fc.PodNets {
+		if ipFamily(podNet.IP) == nftables.TableFamilyIPv6 {
+			return append(families, nftables.TableFamilyIPv6)
+		}
+	}
+	return families
+}
+
 func (fc *FirewallController) BuildConfig(nft *NftWriter) error {
 
 	endpoints, err := fc.EndpointsInformer.Lister().List(labels.Everything())
@@ -38,9 +224,21 @@ func (fc *FirewallController) BuildConfig(nft *NftWriter) error {
 	}
 	sort.Sort(byNamespaceAndName(services))
 
-	nft.StartTableReplacement("kube-pet", nftables.TableFamilyIPv4)
-	rule := NewRuleBuilder()
+	for _, family := range fc.Families() {
+		familyServices, familyEndpoints := fc.familyServices(family, services, endpointMap)
+		fc.buildTable(nft, family, familyServices, familyEndpoints)
+	}
+	return nil
+}
+
+// buildTable writes out the kube-pet table for one address family,
+// given the services which have a ClusterIP in it, see familyServices
+func (fc *FirewallController) buildTable(nft *NftWriter, family nftables.TableFamily, services []*corev1.Service, endpointMap map[string]*corev1.Endpoints) {
+
+	nft.StartTableReplacement("kube-pet", family)
+	rule := NewRuleBuilder(family)
 	podIpsServingClusterIps := make(map[string]string)
+	var nodePorts []nodePortTarget
 
 	for _, svc := range services {
 		key := svc.ObjectMeta.Namespace + "/" + svc.ObjectMeta.Name
@@ -52,10 +250,17 @@ func (fc *FirewallController) BuildConfig(nft *NftWriter) error {
 			continue
 		}
 
-		var healthyAddrs []string
+		var healthyAddrs []endpointTarget
+		seenAddrs := make(map[string]bool)
 		for _, subset := range ep.Subsets {
 			for _, subAddr := range subset.Addresses {
-				healthyAddrs = append(healthyAddrs, subAddr.IP)
+				if !isInFamily(subAddr.IP, family) {
+					continue
+				}
+				if !seenAddrs[subAddr.IP] {
+					seenAddrs[subAddr.IP] = true
+					healthyAddrs = append(healthyAddrs, endpointTarget{IP: subAddr.IP})
+				}
 				// Keep track of pods offering services on the local node
 				if subAddr.NodeName != nil {
 					if *subAddr.NodeName == fc.NodeName {
@@ -64,17 +269,11 @@ func (fc *FirewallController) BuildConfig(nft *NftWriter) error {
 				}
 			}
 		}
+		sortEndpointTargets(healthyAddrs)
 
-		nft.StartBasicChain("svc-" + string(svc.ObjectMeta.UID) + "-dnat")
-
-		for _, port := range svc.Spec.Ports {
-			portName := port.Name
-			if portName == "" {
-				portName = fmt.Sprintf("%v", port.Port)
-			}
-
-			var tgtAddrs []string
-			var tgtPorts []uint16
+		// Each port can have its own endpoints, which can each have their own target port
+		portTargets := make([][]endpointTarget, len(svc.Spec.Ports))
+		for idx, port := range svc.Spec.Ports {
 			for _, subset := range ep.Subsets {
 				samePort := false
 				var tgtPort uint16
@@ -86,43 +285,103 @@ func (fc *FirewallController) BuildConfig(nft *NftWriter) error {
 				}
 				if samePort {
 					for _, subAddr := range subset.Addresses {
-						tgtAddrs = append(tgtAddrs, subAddr.IP)
-						tgtPorts = append(tgtPorts, tgtPort)
+						if !isInFamily(subAddr.IP, family) {
+							continue
+						}
+						isLocal := subAddr.NodeName != nil && *subAddr.NodeName == fc.NodeName
+						portTargets[idx] = append(portTargets[idx], endpointTarget{subAddr.IP, tgtPort, isLocal})
 					}
 				}
 			}
+			sortEndpointTargets(portTargets[idx])
+		}
 
-			// log.Println("Firewall:", key, svc.ObjectMeta.UID, svc.Spec.ClusterIP, ep.Subsets)
-
-			if len(tgtAddrs) > 0 {
+		// log.Println("Firewall:", key, svc.ObjectMeta.UID, svc.Spec.ClusterIP, ep.Subsets)
+
+		// When there's a choice of endpoints, each one gets a chain to DNAT in,
+		// which has to exist before anything can go to it
+		timeout, hasAffinity := affinityTimeout(svc)
+		affinitySets := make(map[string]*nftables.Set)
+		epChains := make(map[string]bool)
+		for listIdx, targets := range append(portTargets, healthyAddrs) {
+			// with session affinity, even a lone endpoint remembers its clients in case others show up
+			sticky := hasAffinity && listIdx < len(portTargets)
+			if len(targets) < 2 && !sticky {
+				continue
+			}
+			for _, target := range targets {
+				chain := target.ChainName(svc)
+				if epChains[chain] {
+					continue
+				}
+				epChains[chain] = true
 
-				rule.Reset()
-				switch port.Protocol {
-				case "TCP":
-					rule.IsTCP()
-				case "UDP":
-					rule.IsUDP()
-				case "SCTP":
-					rule.IsSCTP()
+				if sticky {
+					affinitySets[chain] = newAffinitySet(family, chain, timeout)
+					nft.AddDynamicSet(affinitySets[chain])
 				}
-				rule.IsDestPort(uint16(port.Port))
 
-				// TODO: just uses first addr for now
+				nft.StartBasicChain(chain)
+				if sticky {
+					rule.UpdateSetWithIpSrcAddress(affinitySets[chain])
+				}
 				rule.Counter()
-				rule.TranslateIPv4Destination(tgtAddrs[0], tgtPorts[0])
+				if target.Port == 0 {
+					rule.TranslateAddress(target.IP)
+				} else {
+					rule.TranslateDestination(target.IP, target.Port)
+				}
+				nft.AddRuleWithComment(rule, key)
+				nft.EndChain()
+			}
+		}
+
+		nft.StartBasicChain("svc-" + string(svc.ObjectMeta.UID) + "-dnat")
 
-				nft.AddRuleWithComment(rule, fmt.Sprintf("%v:%v", key, portName))
+		for idx, port := range svc.Spec.Ports {
+			portName := port.Name
+			if portName == "" {
+				portName = fmt.Sprintf("%v", port.Port)
+			}
+
+			tgts := portTargets[idx]
+			if len(tgts) > 0 {
+				port := port
+				addDnatRules(nft, rule, svc, tgts, affinitySets, func() {
+					switch port.Protocol {
+					case "TCP":
+						rule.IsTCP()
+					case "UDP":
+						rule.IsUDP()
+					case "SCTP":
+						rule.IsSCTP()
+					}
+					rule.IsDestPort(uint16(port.Port))
+				}, fmt.Sprintf("%v:%v", key, portName))
+			}
+
+			if port.NodePort != 0 {
+				if svc.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal {
+					tgts = onlyLocalTargets(tgts)
+				} else if len(tgts) == 0 {
+					continue
+				}
+				nodePorts = append(nodePorts, nodePortTarget{svc, port, tgts, affinitySets})
 			}
 		}
 
 		// Route ICMP traffic to anything that's at all healthy
-
-		// TODO: just uses first addr for now
+		// Hashing by source keeps each client's pings going to the same place
 		if len(healthyAddrs) > 0 {
 			rule.Reset()
 			rule.IsICMP()
 			rule.Counter()
-			rule.TranslateIPv4Address(healthyAddrs[0])
+			if len(healthyAddrs) == 1 {
+				rule.TranslateAddress(healthyAddrs[0].IP)
+			} else {
+				rule.JhashIpSrcAddress(uint32(len(healthyAddrs)))
+				rule.GoToChainByNumber(endpointChainNames(svc, healthyAddrs))
+			}
 			nft.AddRuleWithComment(rule, key+" ICMP")
 		}
 
@@ -221,6 +480,85 @@ func (fc *FirewallController) BuildConfig(nft *NftWriter) error {
 
 	nft.EndChain() // cluster-ips-dnat chain
 
+	// Shared chain to dispatch packets based on external IPs.
+	// These addresses can be ours, so only the service's protocols are taken, and never ICMP.
+	nft.StartChain(&nftables.Chain{
+		Name: "external-ips-dnat",
+	})
+
+	for _, svc := range services {
+		key := svc.ObjectMeta.Namespace + "/" + svc.ObjectMeta.Name
+		if _, ok := endpointMap[key]; !ok || svc.Spec.Type == "ExternalName" {
+			continue
+		}
+
+		for _, addr := range externalAddresses(svc) {
+			if !isInFamily(addr, family) {
+				continue
+			}
+			for _, protocol := range servicePortProtocols(svc) {
+				rule.Reset()
+				rule.IsIpDestAddress(addr)
+				switch protocol {
+				case "TCP":
+					rule.IsTCP()
+				case "UDP":
+					rule.IsUDP()
+				case "SCTP":
+					rule.IsSCTP()
+				default:
+					continue
+				}
+				rule.GoToChain("svc-" + string(svc.ObjectMeta.UID) + "-dnat")
+
+				nft.AddRuleWithComment(rule, key)
+			}
+		}
+	}
+
+	nft.EndChain() // external-ips-dnat chain
+
+	// Shared chain to dispatch packets sent to our own addresses, based on node ports.
+	// These go straight to the endpoints, because the service chains match on the service's port.
+	nft.StartChain(&nftables.Chain{
+		Name: "nodeports",
+	})
+	nft.AddRuleWithComment(rule.IsIpDestNetwork(loopbackNet(family)).Return(), "Not routable from loopback")
+
+	for _, nodePort := range nodePorts {
+		key := nodePort.Service.ObjectMeta.Namespace + "/" + nodePort.Service.ObjectMeta.Name
+		portName := nodePort.Port.Name
+		if portName == "" {
+			portName = fmt.Sprintf("%v", nodePort.Port.Port)
+		}
+
+		match := func() {
+			switch nodePort.Port.Protocol {
+			case "TCP":
+				rule.IsTCP()
+			case "UDP":
+				rule.IsUDP()
+			case "SCTP":
+				rule.IsSCTP()
+			}
+			rule.IsDestPort(uint16(nodePort.Port.NodePort))
+		}
+		if len(nodePort.Targets) > 0 {
+			addDnatRules(nft, rule, nodePort.Service, nodePort.Targets, nodePort.AffinitySets, match, fmt.Sprintf("%v:%v", key, portName))
+			continue
+		}
+
+		// externalTrafficPolicy: Local with nothing local, so don't let it reach our own sockets either
+		rule.Reset()
+		match()
+		rule.Counter()
+		rule.Drop()
+
+		nft.AddRuleWithComment(rule, fmt.Sprintf("%v:%v", key, portName))
+	}
+
+	nft.EndChain() // nodeports chain
+
 	// Shared chain to dispatch packets based on cluster IPs
 	nft.StartChain(&nftables.Chain{
 		Name: "cluster-ips-filter",
@@ -269,8 +607,15 @@ func (fc *FirewallController) BuildConfig(nft *NftWriter) error {
 	nft.StartChain(&nftables.Chain{
 		Name: "cluster-outbound-masq",
 	})
-	nft.AddRuleWithComment(rule.IsIpSrcAddress(fc.NodeIP).Return(), "From our Node IP")
+	for _, nodeIP := range fc.NodeIPs {
+		if ipFamily(nodeIP) == family {
+			nft.AddRuleWithComment(rule.IsIpSrcAddress(nodeIP).Return(), "From our Node IP")
+		}
+	}
 	for _, podNet := range fc.PodNets {
+		if ipFamily(podNet.IP) != family {
+			continue
+		}
 		nft.AddRuleWithComment(rule.IsIpSrcNetwork(podNet).Return(), "From our Pod CNI")
 	}
 	nft.AddRuleWithComment(rule.Counter().Masquerade(), "Foreign traffic from us to the cluster")
@@ -308,6 +653,8 @@ func (fc *FirewallController) BuildConfig(nft *NftWriter) error {
 		Priority: nftables.ChainPriorityNATDest,
 	})
 	nft.AddRule(rule.JumpToChain("cluster-ips-dnat")) // TODO: cluster IPs CIDR check
+	nft.AddRule(rule.JumpToChain("external-ips-dnat"))
+	nft.AddRule(rule.IsLocalDestAddress().JumpToChain("nodeports"))
 	nft.EndChain()
 
 	nft.StartChain(&nftables.Chain{
@@ -317,6 +664,8 @@ func (fc *FirewallController) BuildConfig(nft *NftWriter) error {
 		Priority: nftables.ChainPriorityMangle,
 	})
 	nft.AddRule(rule.JumpToChain("cluster-ips-dnat")) // TODO: cluster IPs CIDR check
+	nft.AddRule(rule.JumpToChain("external-ips-dnat"))
+	nft.AddRule(rule.IsLocalDestAddress().JumpToChain("nodeports"))
 	nft.EndChain()
 
 	nft.StartChain(&nftables.Chain{
@@ -330,16 +679,12 @@ func (fc *FirewallController) BuildConfig(nft *NftWriter) error {
 	nft.AddRule(rule.OutIfaceName(fc.VpnIface).GoToChain("cluster-outbound-masq"))
 	// at this point we're leaving and not to the cluster, so maybe we're a pod looking for the internet
 	for _, podNet := range fc.PodNets {
+		if ipFamily(podNet.IP) != family {
+			continue
+		}
 		nft.AddRuleWithComment(rule.IsIpSrcNetwork(podNet).Counter().Masquerade(), "From our Pod CNI")
 	}
 	nft.EndChain()
 
 	nft.EndTable() // kube-pet table
-
-	// TODO: handling multiple backends
-	// TODO: actually use a vmap for this (verdict map) instead of two maps
-	// dnat to numgen inc mod 2 map { 0 : 10.8.0.135 , 1 : 10.8.0.58 }
-	// dnat to numgen inc mod 2 map { 0 : 10.8.0.135 , 1 : 10.8.0.58 } : numgen inc mod 2 map { 0 : 80 , 1 : 8080 }
-
-	return nil
 }
@@ -0,0 +1,357 @@
+package firewall
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const testNodeName = "pet-test"
+
+func testService(name, clusterIP string, mutate func(*corev1.Service)) *corev1.Service {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID("uid-" + name)},
+		Spec: corev1.ServiceSpec{
+			Type:      corev1.ServiceTypeClusterIP,
+			ClusterIP: clusterIP,
+			Ports: []corev1.ServicePort{{
+				Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, TargetPort: intstr.FromString("http"),
+			}},
+		},
+	}
+	if mutate != nil {
+		mutate(svc)
+	}
+	return svc
+}
+
+// testSubset serves the addresses on one port, with a blank nodeName meaning some other node
+func testSubset(port int32, nodeName string, ips ...string) corev1.EndpointSubset {
+	subset := corev1.EndpointSubset{
+		Ports: []corev1.EndpointPort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: port}},
+	}
+	for _, ip := range ips {
+		addr := corev1.EndpointAddress{IP: ip}
+		if nodeName != "" {
+			addr.NodeName = &nodeName
+		}
+		subset.Addresses = append(subset.Addresses, addr)
+	}
+	return subset
+}
+
+// testDualStackService is what the dynamic informer sees of a service, past what our typed client knows about
+func testDualStackService(name string, clusterIPs []string, ipFamilies []string) *unstructured.Unstructured {
+	svc := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"namespace": "default", "name": name},
+		"spec":       map[string]interface{}{},
+	}}
+	unstructured.SetNestedStringSlice(svc.Object, clusterIPs, "spec", "clusterIPs")
+	unstructured.SetNestedStringSlice(svc.Object, ipFamilies, "spec", "ipFamilies")
+	return svc
+}
+
+func testSliceEndpoint(ip string, ready bool) interface{} {
+	return map[string]interface{}{
+		"addresses":  []interface{}{ip},
+		"conditions": map[string]interface{}{"ready": ready},
+	}
+}
+
+func testEndpointSlice(name, service, addressType string, port int64, endpoints ...interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "discovery.k8s.io/v1",
+		"kind":       "EndpointSlice",
+		"metadata": map[string]interface{}{
+			"namespace": "default",
+			"name":      name,
+			"labels":    map[string]interface{}{"kubernetes.io/service-name": service},
+		},
+		"addressType": addressType,
+		"ports":       []interface{}{map[string]interface{}{"name": "http", "protocol": "TCP", "port": port}},
+		"endpoints":   endpoints,
+	}}
+}
+
+func testEndpoints(name string, subsets ...corev1.EndpointSubset) *corev1.Endpoints {
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Subsets:    subsets,
+	}
+}
+
+// renderConfig builds the ruleset text without flushing it, so no privileges are needed
+func renderConfig(t *testing.T, nodeIPs []string, services []*corev1.Service, endpoints []*corev1.Endpoints, dualStack []*unstructured.Unstructured) string {
+	// the informers are never started, we just fill in their caches
+	client := kubernetes.NewForConfigOrDie(&rest.Config{Host: "http://127.0.0.1:1"})
+	factory := informers.NewSharedInformerFactory(client, 0)
+	si := factory.Core().V1().Services()
+	ei := factory.Core().V1().Endpoints()
+	for _, svc := range services {
+		if err := si.Informer().GetIndexer().Add(svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ep := range endpoints {
+		if err := ei.Informer().GetIndexer().Add(ep); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamic.NewForConfigOrDie(&rest.Config{Host: "http://127.0.0.1:1"}), 0)
+	ds := &DualStackInformers{
+		Services:       dynamicFactory.ForResource(servicesResource),
+		EndpointSlices: dynamicFactory.ForResource(endpointSlicesResource),
+	}
+	for _, obj := range dualStack {
+		informer := ds.Services.Informer()
+		if obj.GetKind() == "EndpointSlice" {
+			informer = ds.EndpointSlices.Informer()
+		}
+		if err := informer.GetIndexer().Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ips []net.IP
+	for _, ip := range nodeIPs {
+		ips = append(ips, net.ParseIP(ip))
+	}
+	fc := NewFirewallController(testNodeName, "wg0", ips, nil, si, ei, ds)
+
+	var text strings.Builder
+	if err := fc.BuildConfig(NewNftWriter(&nftables.Conn{}, &text)); err != nil {
+		t.Fatal(err)
+	}
+	return text.String()
+}
+
+// tableText picks out one family's table from the ruleset
+func tableText(ruleset, family string) string {
+	header := "\ntable " + family + " kube-pet {"
+	start := strings.Index(ruleset, header)
+	if start < 0 {
+		return ""
+	}
+	body := ruleset[start+len(header):]
+	if end := strings.Index(body, "\n}\n"); end >= 0 {
+		body = body[:end]
+	}
+	return body
+}
+
+// chainText picks out one chain's rules from a table
+func chainText(table, chain string) string {
+	header := "\n  chain " + chain + " {\n"
+	start := strings.Index(table, header)
+	if start < 0 {
+		return ""
+	}
+	body := table[start+len(header):]
+	if end := strings.Index(body, "\n  }\n"); end >= 0 {
+		body = body[:end]
+	}
+	return body
+}
+
+func TestBuildConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		nodeIPs   []string
+		services  []*corev1.Service
+		endpoints []*corev1.Endpoints
+		dualStack []*unstructured.Unstructured
+
+		family   string
+		chain    string
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "multiple endpoints",
+			nodeIPs:  []string{"192.168.1.2"},
+			services: []*corev1.Service{testService("web", "10.96.0.10", nil)},
+			endpoints: []*corev1.Endpoints{testEndpoints("web",
+				testSubset(8080, "", "10.8.0.5", "10.8.1.5", "10.9.0.7"))},
+			family: "ip",
+			chain:  "svc-uid-web-dnat",
+			contains: []string{
+				`tcp dport 80 counter numgen random mod 3 vmap { 0 : goto svc-uid-web-ep-10.8.0.5-8080, 1 : goto svc-uid-web-ep-10.8.1.5-8080, 2 : goto svc-uid-web-ep-10.9.0.7-8080 } comment "default/web:http"`,
+				`ip protocol icmp counter jhash ip saddr mod 3 vmap`,
+			},
+			excludes: []string{"affinity"},
+		},
+		{
+			name:     "differing target ports",
+			nodeIPs:  []string{"192.168.1.2"},
+			services: []*corev1.Service{testService("web", "10.96.0.10", nil)},
+			endpoints: []*corev1.Endpoints{testEndpoints("web",
+				testSubset(8080, "", "10.8.0.5"),
+				testSubset(9090, "", "10.8.1.5"))},
+			family: "ip",
+			contains: []string{
+				`counter dnat to 10.8.0.5:8080 comment "default/web"`,
+				`counter dnat to 10.8.1.5:9090 comment "default/web"`,
+				`numgen random mod 2 vmap { 0 : goto svc-uid-web-ep-10.8.0.5-8080, 1 : goto svc-uid-web-ep-10.8.1.5-9090 }`,
+			},
+		},
+		{
+			name:     "IPv6 service",
+			nodeIPs:  []string{"192.168.1.2", "fd00::2"},
+			services: []*corev1.Service{testService("web", "fd00:96::10", nil)},
+			endpoints: []*corev1.Endpoints{testEndpoints("web",
+				testSubset(8080, "", "fd00:8::5", "fd00:8:1::5"))},
+			family: "ip6",
+			contains: []string{
+				`ip6 daddr fd00:96::10 goto svc-uid-web-dnat comment "default/web"`,
+				`counter dnat to [fd00:8::5]:8080 comment "default/web"`,
+				`counter dnat to [fd00:8:1::5]:8080 comment "default/web"`,
+				`meta l4proto ipv6-icmp counter jhash ip6 saddr mod 2 vmap`,
+				`ip6 daddr fd00:96::10 counter reject with icmpv6 type port-unreachable comment "default/web"`,
+				`ip6 saddr fd00::2 return comment "From our Node IP"`,
+			},
+			excludes: []string{"ip daddr", "icmp type"},
+		},
+		{
+			name:     "IPv6 service stays out of the IPv4 table",
+			nodeIPs:  []string{"192.168.1.2", "fd00::2"},
+			services: []*corev1.Service{testService("web", "fd00:96::10", nil)},
+			endpoints: []*corev1.Endpoints{testEndpoints("web",
+				testSubset(8080, "", "fd00:8::5"))},
+			family:   "ip",
+			excludes: []string{"default/web", "fd00:"},
+		},
+		{
+			name:     "dual-stack service in its secondary family",
+			nodeIPs:  []string{"192.168.1.2", "fd00::2"},
+			services: []*corev1.Service{testService("web", "10.96.0.10", nil)},
+			endpoints: []*corev1.Endpoints{testEndpoints("web",
+				testSubset(8080, "", "10.8.0.5"))},
+			dualStack: []*unstructured.Unstructured{
+				testDualStackService("web", []string{"10.96.0.10", "fd00:96::10"}, []string{"IPv4", "IPv6"}),
+				testEndpointSlice("web-v4", "web", "IPv4", 8080, testSliceEndpoint("10.8.0.5", true)),
+				testEndpointSlice("web-v6", "web", "IPv6", 8080, testSliceEndpoint("fd00:8::5", true), testSliceEndpoint("fd00:8::6", false)),
+			},
+			family: "ip6",
+			contains: []string{
+				`ip6 daddr fd00:96::10 goto svc-uid-web-dnat comment "default/web"`,
+				`tcp dport 80 counter dnat to [fd00:8::5]:8080 comment "default/web:http"`,
+				`ip6 daddr fd00:96::10 counter reject with icmpv6 type port-unreachable comment "default/web"`,
+			},
+			excludes: []string{"10.96.0.10", "10.8.0.5", "fd00:8::6"},
+		},
+		{
+			name:     "dual-stack service in its primary family",
+			nodeIPs:  []string{"192.168.1.2", "fd00::2"},
+			services: []*corev1.Service{testService("web", "10.96.0.10", nil)},
+			endpoints: []*corev1.Endpoints{testEndpoints("web",
+				testSubset(8080, "", "10.8.0.5"))},
+			dualStack: []*unstructured.Unstructured{
+				testDualStackService("web", []string{"10.96.0.10", "fd00:96::10"}, []string{"IPv4", "IPv6"}),
+				testEndpointSlice("web-v6", "web", "IPv6", 8080, testSliceEndpoint("fd00:8::5", true)),
+			},
+			family: "ip",
+			contains: []string{
+				`ip daddr 10.96.0.10 goto svc-uid-web-dnat comment "default/web"`,
+				`tcp dport 80 counter dnat to 10.8.0.5:8080 comment "default/web:http"`,
+			},
+			excludes: []string{"fd00:"},
+		},
+		{
+			name:    "NodePort with Local policy",
+			nodeIPs: []string{"192.168.1.2"},
+			services: []*corev1.Service{testService("web", "10.96.0.10", func(svc *corev1.Service) {
+				svc.Spec.Type = corev1.ServiceTypeNodePort
+				svc.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeLocal
+				svc.Spec.Ports[0].NodePort = 30080
+			})},
+			endpoints: []*corev1.Endpoints{testEndpoints("web",
+				testSubset(8080, testNodeName, "10.8.0.5"),
+				testSubset(8080, "", "10.9.0.7"))},
+			family: "ip",
+			chain:  "nodeports",
+			contains: []string{
+				`ip daddr 127.0.0.0/8 return comment "Not routable from loopback"`,
+				`tcp dport 30080 counter dnat to 10.8.0.5:8080 comment "default/web:http"`,
+			},
+			excludes: []string{"10.9.0.7", "numgen"},
+		},
+		{
+			name:    "NodePort with Local policy and nothing local",
+			nodeIPs: []string{"192.168.1.2"},
+			services: []*corev1.Service{testService("web", "10.96.0.10", func(svc *corev1.Service) {
+				svc.Spec.Type = corev1.ServiceTypeNodePort
+				svc.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeLocal
+				svc.Spec.Ports[0].NodePort = 30080
+			})},
+			endpoints: []*corev1.Endpoints{testEndpoints("web",
+				testSubset(8080, "", "10.9.0.7"))},
+			family: "ip",
+			chain:  "nodeports",
+			contains: []string{
+				`tcp dport 30080 counter drop comment "default/web:http"`,
+			},
+			excludes: []string{"10.9.0.7"},
+		},
+		{
+			name:    "ClientIP affinity",
+			nodeIPs: []string{"192.168.1.2"},
+			services: []*corev1.Service{testService("web", "10.96.0.10", func(svc *corev1.Service) {
+				timeout := int32(600)
+				svc.Spec.SessionAffinity = corev1.ServiceAffinityClientIP
+				svc.Spec.SessionAffinityConfig = &corev1.SessionAffinityConfig{
+					ClientIP: &corev1.ClientIPConfig{TimeoutSeconds: &timeout},
+				}
+			})},
+			endpoints: []*corev1.Endpoints{testEndpoints("web",
+				testSubset(8080, "", "10.8.0.5", "10.8.1.5"))},
+			family: "ip",
+			contains: []string{
+				"set affinity-svc-uid-web-ep-10.8.0.5-8080 {\n    type ipv4_addr; flags dynamic,timeout; timeout 600s;\n  }",
+				`update @affinity-svc-uid-web-ep-10.8.0.5-8080 { ip saddr } counter dnat to 10.8.0.5:8080 comment "default/web"`,
+				`tcp dport 80 ip saddr @affinity-svc-uid-web-ep-10.8.0.5-8080 goto svc-uid-web-ep-10.8.0.5-8080 comment "default/web:http affinity"`,
+				`tcp dport 80 ip saddr @affinity-svc-uid-web-ep-10.8.1.5-8080 goto svc-uid-web-ep-10.8.1.5-8080 comment "default/web:http affinity"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ruleset := renderConfig(t, tt.nodeIPs, tt.services, tt.endpoints, tt.dualStack)
+			text := tableText(ruleset, tt.family)
+			if text == "" {
+				t.Fatalf("no %s table in ruleset:\n%s", tt.family, ruleset)
+			}
+			if tt.chain != "" {
+				if text = chainText(text, tt.chain); text == "" {
+					t.Fatalf("no %s chain in %s table:\n%s", tt.chain, tt.family, ruleset)
+				}
+			}
+
+			for _, want := range tt.contains {
+				if !strings.Contains(text, want) {
+					t.Errorf("missing %q in:\n%s", want, text)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(text, unwanted) {
+					t.Errorf("unexpected %q in:\n%s", unwanted, text)
+				}
+			}
+		})
+	}
+}
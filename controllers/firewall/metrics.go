@@ -0,0 +1,13 @@
+package firewall
+
+import (
+	"github.com/danopia/kube-pet-node/pkg/metrics"
+)
+
+var (
+	firewallSyncs        = metrics.NewCounterVec("kube_pet_firewall_syncs_total", "Firewall syncs, by whether the ruleset was unchanged, applied, or failed.", "result")
+	firewallSyncDuration = metrics.NewHistogramVec("kube_pet_firewall_sync_duration_seconds", "How long it took to build and apply the ruleset.", metrics.DefBuckets)
+	firewallConfigHash   = metrics.NewGaugeVec("kube_pet_firewall_config_hash", "Hash of the ruleset which is in the kernel.")
+	firewallLastChange   = metrics.NewGaugeVec("kube_pet_firewall_last_change_timestamp_seconds", "When the ruleset in the kernel last changed.")
+	nftablesErrors       = metrics.NewCounterVec("kube_pet_nftables_errors_total", "Errors from sending rulesets to nftables, by kind.", "kind")
+)
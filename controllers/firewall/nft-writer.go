@@ -3,7 +3,9 @@ package firewall
 import (
 	"fmt"
 	"io"
+	"log"
 	"strconv"
+	"strings"
 
 	"github.com/google/nftables"
 	"golang.org/x/sys/unix"
@@ -31,12 +33,12 @@ func (nft *NftWriter) StartTableReplacement(name string, family nftables.TableFa
 		Name:   name,
 		Family: family,
 	}
-	familyStr := "ip" // TODO!!! IPv4 only
+	familyStr := familyName(family)
 
 	nft.conn.AddTable(nft.table)
 	nft.text.Write([]byte("table " + familyStr + " " + name + "\n"))
 	nft.conn.DelTable(nft.table)
-	nft.text.Write([]byte("delete table " + name + "\n"))
+	nft.text.Write([]byte("delete table " + familyStr + " " + name + "\n"))
 
 	nft.conn.AddTable(nft.table)
 	nft.text.Write([]byte("\ntable " + familyStr + " " + name + " {"))
@@ -44,6 +46,20 @@ func (nft *NftWriter) StartTableReplacement(name string, family nftables.TableFa
 	return nft.table
 }
 
+// familyName is how nft spells each family
+func familyName(family nftables.TableFamily) string {
+	switch family {
+	case nftables.TableFamilyIPv4:
+		return "ip"
+	case nftables.TableFamilyIPv6:
+		return "ip6"
+	case nftables.TableFamilyINet:
+		return "inet"
+	default:
+		return "TODO!"
+	}
+}
+
 func (nft *NftWriter) EndTable() {
 	if nft.table != nil {
 		nft.EndChain()
@@ -71,7 +87,7 @@ func (nft *NftWriter) StartChain(chain *nftables.Chain) *nftables.Chain {
 
 	if chain.Type != "" {
 		nft.text.Write([]byte("    type " + chain.Type))
-		switch chain.Hooknum {
+		switch *chain.Hooknum {
 		case unix.NF_INET_PRE_ROUTING:
 			nft.text.Write([]byte(" hook prerouting"))
 		case unix.NF_INET_LOCAL_IN:
@@ -85,13 +101,62 @@ func (nft *NftWriter) StartChain(chain *nftables.Chain) *nftables.Chain {
 		default:
 			nft.text.Write([]byte(" hook TODO!"))
 		}
-		nft.text.Write([]byte(" priority " + strconv.Itoa(int(chain.Priority))))
+		nft.text.Write([]byte(" priority " + strconv.Itoa(int(*chain.Priority))))
 		nft.text.Write([]byte(";\n"))
 	}
 
 	return nft.chain
 }
 
+// AddDynamicSet creates a set of addresses which rules fill in as packets go by.
+// Replacing the table would forget what the kernel has learned so far,
+// so the old set's elements are carried over, but left out of the text so that they don't change the hash.
+// The kernel doesn't say how long they had left, so they start a fresh timeout.
+func (nft *NftWriter) AddDynamicSet(set *nftables.Set) {
+	if nft.table == nil {
+		return
+	}
+	nft.EndChain()
+
+	set.Table = nft.table
+	// fails when the set is new, which is fine
+	elements, _ := nft.conn.GetSetElements(set)
+	for idx := range elements {
+		elements[idx].Timeout = 0
+	}
+	if err := nft.conn.AddSet(set, elements); err != nil {
+		log.Println("Firewall: BUG: Failed to add a set:", err)
+	}
+
+	nft.text.Write([]byte("\n  set " + set.Name + " {\n"))
+	nft.text.Write([]byte("    type " + set.KeyType.Name + ";"))
+	if flags := setFlags(set); len(flags) > 0 {
+		nft.text.Write([]byte(" flags " + strings.Join(flags, ",") + ";"))
+	}
+	if set.HasTimeout {
+		nft.text.Write([]byte(" timeout " + strconv.Itoa(int(set.Timeout.Seconds())) + "s;"))
+	}
+	nft.text.Write([]byte("\n  }\n"))
+}
+
+// setFlags is how nft spells the flags which the set was created with
+func setFlags(set *nftables.Set) []string {
+	var flags []string
+	if set.Constant {
+		flags = append(flags, "constant")
+	}
+	if set.Interval {
+		flags = append(flags, "interval")
+	}
+	if set.Dynamic {
+		flags = append(flags, "dynamic")
+	}
+	if set.HasTimeout {
+		flags = append(flags, "timeout")
+	}
+	return flags
+}
+
 func (nft *NftWriter) EndChain() {
 	if nft.chain != nil {
 		nft.text.Write([]byte("  }\n"))
@@ -99,7 +164,22 @@ func (nft *NftWriter) EndChain() {
 	}
 }
 
+// addMaps creates the anonymous maps which the rule looks up,
+// and points the lookups at them now that they have IDs
+func (nft *NftWriter) addMaps(rule *RuleBuilder) {
+	for _, vmap := range rule.maps {
+		vmap.set.Table = nft.table
+		if err := nft.conn.AddSet(vmap.set, vmap.elements); err != nil {
+			// only happens for maps that aren't constant, so the flush will fail on the lookup
+			log.Println("Firewall: BUG: Failed to add a map:", err)
+		}
+		vmap.lookup.SetName = vmap.set.Name
+		vmap.lookup.SetID = vmap.set.ID
+	}
+}
+
 func (nft *NftWriter) AddRule(rule *RuleBuilder) {
+	nft.addMaps(rule)
 	nft.conn.AddRule(&nftables.Rule{
 		Table: nft.table,
 		Chain: nft.chain,
@@ -110,6 +190,7 @@ func (nft *NftWriter) AddRule(rule *RuleBuilder) {
 }
 
 func (nft *NftWriter) AddRuleWithComment(rule *RuleBuilder, comment string) {
+	nft.addMaps(rule)
 	nft.conn.AddRule(&nftables.Rule{
 		Table:    nft.table,
 		Chain:    nft.chain,
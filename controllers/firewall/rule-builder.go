@@ -6,19 +6,73 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
 	nftexpr "github.com/google/nftables/expr"
 	"golang.org/x/sys/unix"
 )
 
+// RuleBuilder makes rules for one address family,
+// which decides where addresses are in the packet and how long they are
 type RuleBuilder struct {
-	text  *strings.Builder
-	exprs []nftexpr.Any
+	family nftables.TableFamily
+	text   *strings.Builder
+	exprs  []nftexpr.Any
+	maps   []*anonymousMap
 }
 
-func NewRuleBuilder() *RuleBuilder {
+// anonymousMap is a constant map that only exists for one rule,
+// so it gets added to the table right before its rule is
+type anonymousMap struct {
+	set      *nftables.Set
+	elements []nftables.SetElement
+	lookup   *nftexpr.Lookup
+}
+
+func NewRuleBuilder(family nftables.TableFamily) *RuleBuilder {
 	return &RuleBuilder{
-		text: &strings.Builder{},
+		family: family,
+		text:   &strings.Builder{},
+	}
+}
+
+func (rb *RuleBuilder) isIPv6() bool {
+	return rb.family == nftables.TableFamilyIPv6
+}
+
+// "ip" or "ip6", for the text version of address matches
+func (rb *RuleBuilder) ipKeyword() string {
+	if rb.isIPv6() {
+		return "ip6"
+	}
+	return "ip"
+}
+
+// where the source address is in the network header, and how long it is;
+// the destination address comes right after it
+func (rb *RuleBuilder) srcAddrOffset() (uint32, uint32) {
+	if rb.isIPv6() {
+		return 8, 16
+	}
+	return 12, 4
+}
+
+func (rb *RuleBuilder) natFamily() uint32 {
+	if rb.isIPv6() {
+		return unix.NFPROTO_IPV6
+	}
+	return unix.NFPROTO_IPV4
+}
+
+func (rb *RuleBuilder) parseIp(str string) []byte {
+	return rb.ipBytes(net.ParseIP(str))
+}
+
+func (rb *RuleBuilder) ipBytes(ip net.IP) []byte {
+	if rb.isIPv6() {
+		return []byte(ip.To16())
 	}
+	return []byte(ip.To4())
 }
 
 func (rb *RuleBuilder) Exprs() []nftexpr.Any {
@@ -31,6 +85,7 @@ func (rb *RuleBuilder) String() string {
 func (rb *RuleBuilder) Reset() {
 	rb.text.Reset()
 	rb.exprs = []nftexpr.Any{}
+	rb.maps = nil
 }
 
 // "<tcp/udp/sctp> [...]"
@@ -98,28 +153,30 @@ func (rb *RuleBuilder) Counter() *RuleBuilder {
 }
 
 func (rb *RuleBuilder) RejectAsHostUnreachable() *RuleBuilder {
-	rb.exprs = append(rb.exprs,
-		// [ reject type 0 code 1 ]
-		&nftexpr.Reject{
-			Type: 0,
-			Code: 1, // host-unreachable
-		},
-	)
-
-	rb.text.WriteString(" reject with icmp type host-unreachable")
-	return rb
+	// ICMPv6 numbers its unreachable codes differently
+	if rb.isIPv6() {
+		return rb.reject(3, "icmpv6 type addr-unreachable")
+	}
+	return rb.reject(1, "icmp type host-unreachable")
 }
 
 func (rb *RuleBuilder) RejectAsPortUnreachable() *RuleBuilder {
+	if rb.isIPv6() {
+		return rb.reject(4, "icmpv6 type port-unreachable")
+	}
+	return rb.reject(3, "icmp type port-unreachable")
+}
+
+func (rb *RuleBuilder) reject(code uint8, text string) *RuleBuilder {
 	rb.exprs = append(rb.exprs,
-		// [ reject type 0 code 1 ]
+		// [ reject type 0 code N ]
 		&nftexpr.Reject{
-			Type: 0,
-			Code: 3, // port-unreachable
+			Type: unix.NFT_REJECT_ICMP_UNREACH,
+			Code: code,
 		},
 	)
 
-	rb.text.WriteString(" reject with icmp type port-unreachable")
+	rb.text.WriteString(" reject with " + text)
 	return rb
 }
 
@@ -135,6 +192,18 @@ func (rb *RuleBuilder) Accept() *RuleBuilder {
 	return rb
 }
 
+func (rb *RuleBuilder) Drop() *RuleBuilder {
+	rb.exprs = append(rb.exprs,
+		// [ immediate reg 0 drop ]
+		&nftexpr.Verdict{
+			Kind: nftexpr.VerdictDrop,
+		},
+	)
+
+	rb.text.WriteString(" drop")
+	return rb
+}
+
 func (rb *RuleBuilder) Return() *RuleBuilder {
 	rb.exprs = append(rb.exprs,
 		// [ immediate reg 0 return ]
@@ -147,17 +216,17 @@ func (rb *RuleBuilder) Return() *RuleBuilder {
 	return rb
 }
 
-func (rb *RuleBuilder) TranslateIPv4Address(ip string) *RuleBuilder {
+func (rb *RuleBuilder) TranslateAddress(ip string) *RuleBuilder {
 	rb.exprs = append(rb.exprs,
 		// [ immediate reg 1 0x8700080a ]
 		&nftexpr.Immediate{
 			Register: 1,
-			Data:     parseIpForNft(ip),
+			Data:     rb.parseIp(ip),
 		},
 		// [ nat dnat ip addr_min reg 1 addr_max reg 0flags 0x2 ]
 		&nftexpr.NAT{
 			Type:       nftexpr.NATTypeDestNAT,
-			Family:     unix.NFPROTO_IPV4,
+			Family:     rb.natFamily(),
 			RegAddrMin: 1,
 			RegAddrMax: 0,
 		},
@@ -168,12 +237,12 @@ func (rb *RuleBuilder) TranslateIPv4Address(ip string) *RuleBuilder {
 	return rb
 }
 
-func (rb *RuleBuilder) TranslateIPv4Destination(ip string, port uint16) *RuleBuilder {
+func (rb *RuleBuilder) TranslateDestination(ip string, port uint16) *RuleBuilder {
 	rb.exprs = append(rb.exprs,
 		// [ immediate reg 1 0x8700080a ]
 		&nftexpr.Immediate{
 			Register: 1,
-			Data:     parseIpForNft(ip),
+			Data:     rb.parseIp(ip),
 		},
 		// [ immediate reg 2 0x00003500 ]
 		&nftexpr.Immediate{
@@ -183,7 +252,7 @@ func (rb *RuleBuilder) TranslateIPv4Destination(ip string, port uint16) *RuleBui
 		// [ nat dnat ip addr_min reg 1 addr_max reg 0 proto_min reg 2 proto_max reg 0 flags 0x2 ]
 		&nftexpr.NAT{
 			Type:        nftexpr.NATTypeDestNAT,
-			Family:      unix.NFPROTO_IPV4,
+			Family:      rb.natFamily(),
 			RegAddrMin:  1,
 			RegAddrMax:  0,
 			RegProtoMin: 2,
@@ -195,20 +264,29 @@ func (rb *RuleBuilder) TranslateIPv4Destination(ip string, port uint16) *RuleBui
 	)
 
 	rb.text.WriteString(" dnat to ")
-	rb.text.WriteString(ip)
+	if rb.isIPv6() {
+		rb.text.WriteString("[" + ip + "]")
+	} else {
+		rb.text.WriteString(ip)
+	}
 	rb.text.WriteRune(':')
 	rb.text.WriteString(strconv.FormatUint(uint64(port), 10))
 	return rb
 }
 
 func (rb *RuleBuilder) IsIpProtocol(id byte, name string) *RuleBuilder {
+	// IPv6 calls it the next header
+	var offset uint32 = 9
+	if rb.isIPv6() {
+		offset = 6
+	}
 	rb.exprs = append(rb.exprs,
 		// [ payload load 1b @ network header + 9 => reg 1 ]
 		&nftexpr.Payload{
 			DestRegister: 1,
 			Base:         nftexpr.PayloadBaseNetworkHeader,
-			Offset:       9, // TODO
-			Len:          1, // TODO
+			Offset:       offset,
+			Len:          1,
 		},
 		// [ cmp eq reg 1 0x00000001 ]
 		&nftexpr.Cmp{
@@ -218,33 +296,42 @@ func (rb *RuleBuilder) IsIpProtocol(id byte, name string) *RuleBuilder {
 		},
 	)
 
-	rb.text.WriteString(" ip protocol ")
+	if rb.isIPv6() {
+		rb.text.WriteString(" ip6 nexthdr ")
+	} else {
+		rb.text.WriteString(" ip protocol ")
+	}
 	rb.text.WriteString(name)
 	return rb
 }
 
 func (rb *RuleBuilder) IsICMP() *RuleBuilder {
+	if rb.isIPv6() {
+		// IPv6 can have extension headers before ICMP, which l4proto skips over
+		return rb.IsL4Proto(unix.IPPROTO_ICMPV6, "meta l4proto ipv6-icmp")
+	}
 	return rb.IsIpProtocol(unix.IPPROTO_ICMP, "icmp")
 }
 
 func (rb *RuleBuilder) IsIpDestAddress(ip string) *RuleBuilder {
+	offset, length := rb.srcAddrOffset()
 	rb.exprs = append(rb.exprs,
 		// [ payload load 4b @ network header + 16 => reg 1 ]
 		&nftexpr.Payload{
 			DestRegister: 1,
 			Base:         nftexpr.PayloadBaseNetworkHeader,
-			Offset:       16,
-			Len:          4,
+			Offset:       offset + length,
+			Len:          length,
 		},
 		// [ cmp eq reg 1 0x4b0f060a ]
 		&nftexpr.Cmp{
 			Op:       nftexpr.CmpOpEq,
 			Register: 1,
-			Data:     parseIpForNft(ip),
+			Data:     rb.parseIp(ip),
 		},
 	)
 
-	rb.text.WriteString(" ip daddr ")
+	rb.text.WriteString(" " + rb.ipKeyword() + " daddr ")
 	rb.text.WriteString(ip)
 	return rb
 }
@@ -253,57 +340,145 @@ func (rb *RuleBuilder) IsIpSrcAddressStr(ip string) *RuleBuilder {
 	return rb.IsIpSrcAddress(net.ParseIP(ip))
 }
 func (rb *RuleBuilder) IsIpSrcAddress(ip net.IP) *RuleBuilder {
+	offset, length := rb.srcAddrOffset()
 	rb.exprs = append(rb.exprs,
-		// [ payload load 4b @ network header + 16 => reg 1 ]
+		// [ payload load 4b @ network header + 12 => reg 1 ]
 		&nftexpr.Payload{
 			DestRegister: 1,
 			Base:         nftexpr.PayloadBaseNetworkHeader,
-			Offset:       12,
-			Len:          4,
+			Offset:       offset,
+			Len:          length,
 		},
 		// [ cmp eq reg 1 0x4b0f060a ]
 		&nftexpr.Cmp{
 			Op:       nftexpr.CmpOpEq,
 			Register: 1,
-			Data:     []byte(ip.To4()),
+			Data:     rb.ipBytes(ip),
 		},
 	)
 
-	rb.text.WriteString(" ip saddr ")
+	rb.text.WriteString(" " + rb.ipKeyword() + " saddr ")
 	rb.text.WriteString(ip.String())
 	return rb
 }
 
+// "ip saddr @<set>"
+func (rb *RuleBuilder) IsIpSrcAddressInSet(set *nftables.Set) *RuleBuilder {
+	offset, length := rb.srcAddrOffset()
+	rb.exprs = append(rb.exprs,
+		// [ payload load 4b @ network header + 12 => reg 1 ]
+		&nftexpr.Payload{
+			DestRegister: 1,
+			Base:         nftexpr.PayloadBaseNetworkHeader,
+			Offset:       offset,
+			Len:          length,
+		},
+		// [ lookup reg 1 set affinity-... ]
+		&nftexpr.Lookup{
+			SourceRegister: 1,
+			SetName:        set.Name,
+			SetID:          set.ID,
+		},
+	)
+
+	rb.text.WriteString(" " + rb.ipKeyword() + " saddr @")
+	rb.text.WriteString(set.Name)
+	return rb
+}
+
+// "update @<set> { ip saddr }"
+// adds the source address to the set, or restarts its timeout if it's already there
+func (rb *RuleBuilder) UpdateSetWithIpSrcAddress(set *nftables.Set) *RuleBuilder {
+	offset, length := rb.srcAddrOffset()
+	rb.exprs = append(rb.exprs,
+		// [ payload load 4b @ network header + 12 => reg 1 ]
+		&nftexpr.Payload{
+			DestRegister: 1,
+			Base:         nftexpr.PayloadBaseNetworkHeader,
+			Offset:       offset,
+			Len:          length,
+		},
+		// [ dynset update reg_key 1 set affinity-... ]
+		&nftexpr.Dynset{
+			SrcRegKey: 1,
+			SetName:   set.Name,
+			SetID:     set.ID,
+			Operation: unix.NFT_DYNSET_OP_UPDATE,
+		},
+	)
+
+	rb.text.WriteString(" update @")
+	rb.text.WriteString(set.Name)
+	rb.text.WriteString(" { " + rb.ipKeyword() + " saddr }")
+	return rb
+}
+
 func (rb *RuleBuilder) IsIpSrcNetwork(ipNet net.IPNet) *RuleBuilder {
+	offset, _ := rb.srcAddrOffset()
+	return rb.isIpNetwork(offset, "saddr", ipNet)
+}
+
+func (rb *RuleBuilder) IsIpDestNetwork(ipNet net.IPNet) *RuleBuilder {
+	offset, length := rb.srcAddrOffset()
+	return rb.isIpNetwork(offset+length, "daddr", ipNet)
+}
+
+func (rb *RuleBuilder) isIpNetwork(offset uint32, field string, ipNet net.IPNet) *RuleBuilder {
+	_, length := rb.srcAddrOffset()
+	// IPv4 masks can come in either length
+	ones, _ := ipNet.Mask.Size()
+	mask := net.CIDRMask(ones, int(length)*8)
 	rb.exprs = append(rb.exprs,
-		// [ payload load 4b @ network header + 16 => reg 1 ]
+		// [ payload load 4b @ network header + 12 => reg 1 ]
 		&nftexpr.Payload{
 			DestRegister: 1,
 			Base:         nftexpr.PayloadBaseNetworkHeader,
-			Offset:       12,
-			Len:          4,
+			Offset:       offset,
+			Len:          length,
 		},
 		// [ bitwise reg 1 = (reg=1 & 0xffffff80 ) ^ 0x00000000 ]
 		&nftexpr.Bitwise{
 			SourceRegister: 1,
 			DestRegister:   1,
-			Len:            4,
-			Mask:           []byte(ipNet.Mask),
-			Xor:            []byte{0x0, 0x0, 0x0, 0x0},
+			Len:            length,
+			Mask:           []byte(mask),
+			Xor:            make([]byte, length),
 		},
 		// [ cmp eq reg 1 0x4b0f060a ]
 		&nftexpr.Cmp{
 			Op:       nftexpr.CmpOpEq,
 			Register: 1,
-			Data:     []byte(ipNet.IP.To4()),
+			Data:     rb.ipBytes(ipNet.IP.Mask(mask)),
 		},
 	)
 
-	rb.text.WriteString(" ip saddr ")
+	rb.text.WriteString(" " + rb.ipKeyword() + " " + field + " ")
 	rb.text.WriteString(ipNet.String())
 	return rb
 }
 
+// "fib daddr type local"
+// matches packets addressed to any of this machine's addresses
+func (rb *RuleBuilder) IsLocalDestAddress() *RuleBuilder {
+	rb.exprs = append(rb.exprs,
+		// [ fib daddr type => reg 1 ]
+		&nftexpr.Fib{
+			Register:       1,
+			FlagDADDR:      true,
+			ResultADDRTYPE: true,
+		},
+		// [ cmp eq reg 1 0x00000002 ]
+		&nftexpr.Cmp{
+			Op:       nftexpr.CmpOpEq,
+			Register: 1,
+			Data:     binaryutil.NativeEndian.PutUint32(unix.RTN_LOCAL),
+		},
+	)
+
+	rb.text.WriteString(" fib daddr type local")
+	return rb
+}
+
 func (rb *RuleBuilder) OutIfaceName(iface string) *RuleBuilder {
 	rb.exprs = append(rb.exprs,
 		// [ meta load oifname => reg 1 ]
@@ -360,6 +535,92 @@ func (rb *RuleBuilder) GoToChain(chain string) *RuleBuilder {
 	return rb
 }
 
+// "numgen random mod <n>"
+// picks a number for each new connection, which conntrack then remembers
+func (rb *RuleBuilder) NumgenRandom(modulus uint32) *RuleBuilder {
+	rb.exprs = append(rb.exprs,
+		// [ numgen reg 1 = random mod 2 ]
+		&nftexpr.Numgen{
+			Register: 1,
+			Modulus:  modulus,
+			Type:     unix.NFT_NG_RANDOM,
+		},
+	)
+
+	rb.text.WriteString(" numgen random mod ")
+	rb.text.WriteString(strconv.FormatUint(uint64(modulus), 10))
+	return rb
+}
+
+// "jhash ip saddr mod <n>"
+// picks the same number every time for each source address
+func (rb *RuleBuilder) JhashIpSrcAddress(modulus uint32) *RuleBuilder {
+	offset, length := rb.srcAddrOffset()
+	rb.exprs = append(rb.exprs,
+		// [ payload load 4b @ network header + 12 => reg 2 ]
+		&nftexpr.Payload{
+			DestRegister: 2,
+			Base:         nftexpr.PayloadBaseNetworkHeader,
+			Offset:       offset,
+			Len:          length,
+		},
+		// [ hash reg 1 = jhash(reg 2, 4, 0x0) % mod 2 ]
+		&nftexpr.Hash{
+			SourceRegister: 2,
+			DestRegister:   1,
+			Length:         length,
+			Modulus:        modulus,
+			Type:           nftexpr.HashTypeJenkins,
+		},
+	)
+
+	rb.text.WriteString(" jhash " + rb.ipKeyword() + " saddr mod ")
+	rb.text.WriteString(strconv.FormatUint(uint64(modulus), 10))
+	return rb
+}
+
+// "vmap { 0 : goto <chain>, 1 : goto <chain> }"
+// switches to one of the chains based on a number from NumgenRandom or JhashIpSrcAddress
+func (rb *RuleBuilder) GoToChainByNumber(chains []string) *RuleBuilder {
+	vmap := &anonymousMap{
+		set: &nftables.Set{
+			Anonymous: true,
+			Constant:  true,
+			IsMap:     true,
+			KeyType:   nftables.TypeInteger,
+			DataType:  nftables.TypeVerdict,
+		},
+		// [ lookup reg 1 set __map%d dreg 0 ]
+		lookup: &nftexpr.Lookup{
+			SourceRegister: 1,
+			DestRegister:   0,
+			IsDestRegSet:   true,
+		},
+	}
+
+	rb.text.WriteString(" vmap {")
+	for idx, chain := range chains {
+		vmap.elements = append(vmap.elements, nftables.SetElement{
+			// numgen and hash leave their number in host order
+			Key: binaryutil.NativeEndian.PutUint32(uint32(idx)),
+			VerdictData: &nftexpr.Verdict{
+				Kind:  nftexpr.VerdictGoto,
+				Chain: chain,
+			},
+		})
+
+		if idx > 0 {
+			rb.text.WriteRune(',')
+		}
+		rb.text.WriteString(" " + strconv.Itoa(idx) + " : goto " + chain)
+	}
+	rb.text.WriteString(" }")
+
+	rb.exprs = append(rb.exprs, vmap.lookup)
+	rb.maps = append(rb.maps, vmap)
+	return rb
+}
+
 // perform automatic source NAT
 func (rb *RuleBuilder) Masquerade() *RuleBuilder {
 	rb.exprs = append(rb.exprs,
@@ -371,14 +632,6 @@ func (rb *RuleBuilder) Masquerade() *RuleBuilder {
 	return rb
 }
 
-func parseIpForNft(str string) []byte {
-	ip := []byte(net.ParseIP(str).To4())
-	// for i, j := 0, len(ip)-1; i < j; i, j = i+1, j-1 {
-	//   ip[i], ip[j] = ip[j], ip[i]
-	// }
-	return ip
-}
-
 func parsePortForNft(port uint16) []byte {
 	portBytes := make([]byte, 2)
 	binary.BigEndian.PutUint16(portBytes, port)
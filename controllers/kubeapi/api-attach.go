@@ -0,0 +1,140 @@
+package kubeapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/tools/remotecommand"
+	kuberemotecommand "k8s.io/kubernetes/pkg/kubelet/server/remotecommand"
+
+	"github.com/danopia/kube-pet-node/controllers/pods"
+	"github.com/danopia/kube-pet-node/pkg/podman"
+)
+
+// kubelet's default --streaming-connection-idle-timeout
+const streamIdleTimeout = 4 * time.Hour
+
+// ServeAttach handles /attach/{namespace}/{pod}/{container}, which virtual-kubelet doesn't route.
+// kubelet also accepts the pod UID before the container name, so we do too.
+func (ka *KubeApi) ServeAttach(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/attach/"), "/")
+	var podUID types.UID
+	switch len(parts) {
+	case 3:
+	case 4:
+		podUID = types.UID(parts[2])
+		parts = []string{parts[0], parts[1], parts[3]}
+	default:
+		http.Error(w, "expected /attach/{namespace}/{pod}/{container}", http.StatusNotFound)
+		return
+	}
+	podCoord := pods.PodCoord{Namespace: parts[0], Name: parts[1]}
+
+	streamOpts, err := kuberemotecommand.NewOptions(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	kuberemotecommand.ServeAttach(w, req, attacherFunc(func(name string, uid types.UID, container string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+		return ka.AttachContainer(req.Context(), podCoord, container, in, out, errOut, tty, resize)
+	}), podCoord.Key(), podUID, parts[2], streamOpts, streamIdleTimeout, remotecommandconsts.DefaultStreamCreationTimeout, remotecommandconsts.SupportedStreamingProtocols)
+}
+
+type attacherFunc func(name string, uid types.UID, container string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error
+
+func (af attacherFunc) AttachContainer(name string, uid types.UID, container string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	return af(name, uid, container, in, out, errOut, tty, resize)
+}
+
+func (ka *KubeApi) AttachContainer(ctx context.Context, podCoord pods.PodCoord, containerName string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	log.Println("AttachContainer(", podCoord, containerName, tty, ")")
+
+	if ka.isHostPod(podCoord.Namespace, podCoord.Name) {
+		return fmt.Errorf("attaching to the host isn't supported, try exec instead")
+	}
+
+	known, ok := ka.podManager.GetPod(podCoord.Key())
+	if !ok || known.Kube == nil {
+		return fmt.Errorf("pod %v not found", podCoord)
+	}
+	var conSpec *corev1.Container
+	for idx := range known.Kube.Spec.Containers {
+		if known.Kube.Spec.Containers[idx].Name == containerName {
+			conSpec = &known.Kube.Spec.Containers[idx]
+		}
+	}
+	if conSpec == nil {
+		return fmt.Errorf("container %s not found in pod %v", containerName, podCoord)
+	}
+
+	// the container decides these, not the client
+	if in != nil && !conSpec.Stdin {
+		log.Println("kubeapi: Ignoring stdin for attach because", containerName, "doesn't have stdin enabled")
+		in = nil
+	}
+	tty = conSpec.TTY
+
+	input, output, err := ka.podManager.AttachToContainer(ctx, podCoord, containerName, &podman.ContainerAttachOptions{
+		Stdin:  in != nil,
+		Stdout: out != nil,
+		Stderr: errOut != nil,
+	})
+	if err != nil {
+		log.Println("attach init err:", err)
+		return err
+	}
+	defer output.Close()
+
+	if resize != nil {
+		go func() {
+			for termSize := range resize {
+				if err := ka.podManager.ResizeContainer(ctx, podCoord, containerName, termSize.Width, termSize.Height); err != nil {
+					log.Println("kubeapi WARN: Resize attach to", termSize.Width, termSize.Height, "failed:", err)
+				}
+			}
+		}()
+	}
+
+	if in != nil && input != nil {
+		go func() {
+			io.Copy(input, in)
+			if conSpec.StdinOnce {
+				// dropping the attach makes conmon close the container's stdin, which is what StdinOnce wants
+				log.Println("kubeapi: Closing stdin of", podCoord, containerName, "after the first attach")
+				output.Close()
+			}
+		}()
+	}
+
+	if out == nil {
+		out = nopWriteCloser{ioutil.Discard}
+	}
+	if errOut == nil {
+		errOut = nopWriteCloser{ioutil.Discard}
+	}
+
+	if tty {
+		io.Copy(out, output)
+	} else {
+		podman.DemuxRawStream(output, out, errOut, false)
+	}
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,46 @@
+package kubeapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// AddDebugHandler serves troubleshooting info at /debug/<name>, behind the same auth as everything else
+func (ka *KubeApi) AddDebugHandler(name string, handler http.HandlerFunc) {
+	ka.debugMux.HandleFunc("/debug/"+name, handler)
+}
+
+type debugPod struct {
+	Key          string
+	UID          types.UID
+	PodId        string
+	ContainerIDs map[string]string
+}
+
+// ServeDebugPods dumps the PodManager's registry, to compare against what podman and the API server think
+func (ka *KubeApi) ServeDebugPods(w http.ResponseWriter, req *http.Request) {
+	knownPods := ka.podManager.SnapshotPods()
+	list := make([]debugPod, 0, len(knownPods))
+	for key, pod := range knownPods {
+		entry := debugPod{
+			Key:          key,
+			PodId:        pod.PodId,
+			ContainerIDs: pod.ContainerIDs,
+		}
+		if pod.Kube != nil {
+			entry.UID = pod.Kube.ObjectMeta.UID
+		}
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Key < list[j].Key
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(list)
+}
@@ -18,7 +18,11 @@ import (
 func (ka *KubeApi) RunInContainer(ctx context.Context, namespace, podName, containerName string, cmd []string, attach vkapi.AttachIO) error {
 	log.Println("RunInContainer(", namespace, podName, containerName, cmd, attach, ")")
 
-	session, err := ka.podManager.StartExecInPod(ctx, pods.PodCoord{namespace, podName}, containerName, &podman.ContainerExecOptions{
+	if ka.isHostPod(namespace, podName) {
+		return ka.RunOnHost(ctx, containerName, cmd, attach)
+	}
+
+	session, err := ka.podManager.StartExecInPod(ctx, pods.PodCoord{Namespace: namespace, Name: podName}, containerName, &podman.ContainerExecOptions{
 		Cmd:          cmd,
 		Tty:          attach.TTY(),
 		AttachStdin:  attach.Stdin() != nil,
@@ -0,0 +1,228 @@
+package kubeapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	vkapi "github.com/virtual-kubelet/virtual-kubelet/node/api"
+
+	"github.com/danopia/kube-pet-node/controllers/pods"
+)
+
+// isHostPod is true when a request targets our fake pod which represents the node itself
+func (ka *KubeApi) isHostPod(namespace, podName string) bool {
+	return namespace == pods.HostPodNamespace && podName == pods.HostPodName(ka.nodeName)
+}
+
+// RunOnHost runs a command in the host's namespaces by entering PID 1.
+// This is a root shell on the node, so it has to be turned on with --enable-host-exec.
+func (ka *KubeApi) RunOnHost(ctx context.Context, containerName string, cmd []string, attach vkapi.AttachIO) error {
+	if containerName != pods.HostContainerName {
+		return fmt.Errorf("container %s not found in host pod", containerName)
+	}
+	if !ka.hostExec {
+		return fmt.Errorf("exec on the host is disabled, start kube-pet-node with --enable-host-exec to allow it")
+	}
+	if len(cmd) == 0 {
+		return fmt.Errorf("no command given")
+	}
+	log.Println("kubeapi: Running", cmd, "on the host")
+
+	args := append([]string{"nsenter", "--target", "1", "--mount", "--uts", "--ipc", "--net", "--pid", "--"}, cmd...)
+	var proc *exec.Cmd
+	if os.Getuid() == 0 {
+		proc = exec.CommandContext(ctx, args[0], args[1:]...)
+	} else {
+		// needs the opt-in rule from gradle/dist/sudoers-host-exec, and never prompt for a password
+		proc = exec.CommandContext(ctx, "sudo", append([]string{"--non-interactive"}, args...)...)
+	}
+
+	if attach.TTY() {
+		return runWithPty(proc, attach)
+	}
+
+	var stdin io.WriteCloser
+	if attach.Stdin() != nil {
+		var err error
+		if stdin, err = proc.StdinPipe(); err != nil {
+			return err
+		}
+	}
+	if attach.Stdout() != nil {
+		proc.Stdout = attach.Stdout()
+	}
+	if attach.Stderr() != nil {
+		proc.Stderr = attach.Stderr()
+	}
+
+	if err := proc.Start(); err != nil {
+		return err
+	}
+	if stdin != nil {
+		go func() {
+			io.Copy(stdin, attach.Stdin())
+			stdin.Close()
+		}()
+	}
+	return hostExitError(proc.Wait())
+}
+
+// runWithPty gives the command a real terminal so that interactive shells behave
+func runWithPty(proc *exec.Cmd, attach vkapi.AttachIO) error {
+	ptm, pts, err := openPty()
+	if err != nil {
+		return err
+	}
+	defer ptm.Close()
+
+	proc.Stdin = pts
+	proc.Stdout = pts
+	proc.Stderr = pts
+	proc.SysProcAttr = &syscall.SysProcAttr{
+		Setsid:  true,
+		Setctty: true,
+	}
+
+	err = proc.Start()
+	pts.Close()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for termSize := range attach.Resize() {
+			if err := unix.IoctlSetWinsize(int(ptm.Fd()), unix.TIOCSWINSZ, &unix.Winsize{
+				Row: termSize.Height,
+				Col: termSize.Width,
+			}); err != nil {
+				log.Println("kubeapi WARN: Resize host terminal to", termSize.Width, termSize.Height, "failed:", err)
+			}
+		}
+	}()
+
+	if attach.Stdin() != nil {
+		go io.Copy(ptm, attach.Stdin())
+	}
+
+	// reading the pty fails with EIO once the shell exits, which is our EOF
+	io.Copy(attach.Stdout(), ptm)
+	return hostExitError(proc.Wait())
+}
+
+func openPty() (*os.File, *os.File, error) {
+	ptm, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := unix.IoctlSetPointerInt(int(ptm.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		ptm.Close()
+		return nil, nil, fmt.Errorf("unlocking pty: %w", err)
+	}
+	ptyNum, err := unix.IoctlGetInt(int(ptm.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		ptm.Close()
+		return nil, nil, fmt.Errorf("finding pty: %w", err)
+	}
+
+	pts, err := os.OpenFile("/dev/pts/"+strconv.Itoa(ptyNum), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		ptm.Close()
+		return nil, nil, err
+	}
+	return ptm, pts, nil
+}
+
+func hostExitError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+		return exitError{exitErr.ExitCode()}
+	}
+	return err
+}
+
+// GetHostLogs streams the kernel log from journald, or from dmesg when journald isn't around.
+// --previous shows the kernel log from the last boot, which is handy after a crash.
+func (ka *KubeApi) GetHostLogs(ctx context.Context, containerName string, opts vkapi.ContainerLogOpts) (io.ReadCloser, error) {
+	if containerName != pods.HostContainerName {
+		return nil, fmt.Errorf("container %s not found in host pod", containerName)
+	}
+
+	var proc *exec.Cmd
+	if _, err := exec.LookPath("journalctl"); err == nil {
+		args := []string{"--dmesg", "--no-pager", "--quiet"}
+		if opts.Timestamps {
+			args = append(args, "--output=short-iso-precise")
+		} else {
+			args = append(args, "--output=cat")
+		}
+		if opts.Previous {
+			args = append(args, "--boot=-1")
+		}
+		if opts.Follow {
+			args = append(args, "--follow")
+		}
+		if opts.Tail > 0 {
+			args = append(args, "--lines="+strconv.Itoa(opts.Tail))
+		}
+		if opts.SinceSeconds > 0 {
+			args = append(args, "--since=-"+strconv.Itoa(opts.SinceSeconds)+"s")
+		} else if !opts.SinceTime.IsZero() {
+			args = append(args, "--since=@"+strconv.FormatInt(opts.SinceTime.Unix(), 10))
+		}
+		proc = exec.CommandContext(ctx, "journalctl", args...)
+
+	} else {
+		// dmesg can't pick out lines for us, so don't pretend that it did
+		if opts.Previous {
+			return nil, fmt.Errorf("the previous boot's kernel log needs journald")
+		}
+		if opts.Tail > 0 || opts.SinceSeconds > 0 || !opts.SinceTime.IsZero() {
+			return nil, fmt.Errorf("tailing the kernel log, or reading it since a time, needs journald")
+		}
+		args := []string{}
+		if opts.Timestamps {
+			args = append(args, "--time-format=iso")
+		}
+		if opts.Follow {
+			args = append(args, "--follow")
+		}
+		proc = exec.CommandContext(ctx, "dmesg", args...)
+	}
+
+	output, err := proc.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	proc.Stderr = proc.Stdout
+	if err := proc.Start(); err != nil {
+		return nil, err
+	}
+
+	stream := &hostLogStream{Reader: output, output: output, proc: proc}
+	if opts.LimitBytes > 0 {
+		stream.Reader = io.LimitReader(output, int64(opts.LimitBytes))
+	}
+	return stream, nil
+}
+
+type hostLogStream struct {
+	io.Reader
+	output io.Closer
+	proc   *exec.Cmd
+}
+
+// Close also stops the log command, which is still running if we were following
+func (hls *hostLogStream) Close() error {
+	hls.proc.Process.Kill()
+	hls.output.Close()
+	hls.proc.Wait()
+	return nil
+}
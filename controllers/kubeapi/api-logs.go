@@ -1,14 +1,16 @@
 package kubeapi
 
 import (
-	"bytes"
+	"bufio"
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"os"
 	"strconv"
-
-	// utilexec "k8s.io/utils/exec"
+	"strings"
+	"time"
 
 	vkapi "github.com/virtual-kubelet/virtual-kubelet/node/api"
 
@@ -20,22 +22,52 @@ func (ka *KubeApi) GetContainerLogs(ctx context.Context, namespace, podName, con
 	log.Println("GetContainerLogs(", namespace, podName, containerName, opts, ")")
 	log.Printf("%+v", opts)
 
+	if ka.isHostPod(namespace, podName) {
+		return ka.GetHostLogs(ctx, containerName, opts)
+	}
+	podCoord := pods.PodCoord{Namespace: namespace, Name: podName}
+
 	// https://godoc.org/github.com/virtual-kubelet/virtual-kubelet/node/api#ContainerLogOpts
+	var since time.Time
+	if opts.SinceSeconds > 0 {
+		since = time.Now().Add(-time.Duration(opts.SinceSeconds) * time.Second)
+	} else if !opts.SinceTime.IsZero() {
+		since = opts.SinceTime
+	}
+
 	if opts.Previous {
-		return ioutil.NopCloser(bytes.NewReader([]byte("TODO: kube-pet-node doesn't support --previous=true"))), nil
+		known, ok := ka.podManager.GetPod(podCoord.Key())
+		if !ok || known.Kube == nil {
+			return nil, fmt.Errorf("pod %v not found", podCoord)
+		}
+		logs, err := ka.podManager.FetchPreviousLogs(known.Kube.ObjectMeta.UID, containerName)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("previous terminated container %q in pod %q not found", containerName, podName)
+		} else if err != nil {
+			return nil, err
+		}
+		if opts.Tail > 0 {
+			if logs, err = tailLines(logs, opts.Tail); err != nil {
+				return nil, err
+			}
+		}
+		return formatLogs(logs, opts, since), nil
 	}
+
+	// we always want podman's timestamps, for filtering and reformatting
 	logOpts := &podman.ContainerLogsOptions{
-		Timestamps: opts.Timestamps,
+		Timestamps: true,
 		Follow:     opts.Follow,
 	}
-	// TODO: LimitBytes   int
-	// TODO: SinceSeconds int
-	// TODO: SinceTime    time.Time
 	if opts.Tail > 0 {
 		logOpts.Tail = strconv.Itoa(opts.Tail)
 	}
+	if !since.IsZero() {
+		// podman only goes to the second, so the rest is filtered as we read
+		logOpts.Since = since.UTC().Format(time.RFC3339)
+	}
 
-	logs, err := ka.podManager.FetchContainerLogs(ctx, pods.PodCoord{namespace, podName}, containerName, logOpts)
+	logs, err := ka.podManager.FetchContainerLogs(ctx, podCoord, containerName, logOpts)
 	if err != nil {
 		log.Println("logs get err:", err)
 		return nil, err
@@ -44,5 +76,85 @@ func (ka *KubeApi) GetContainerLogs(ctx context.Context, namespace, podName, con
 	// kubernetes mixes stdout/stderr so just use one pipe for everything
 	outR, outW := io.Pipe()
 	go podman.DemuxRawStream(logs, outW, outW, true)
-	return outR, nil
+	return formatLogs(&logStream{outR, []io.Closer{outR, logs}}, opts, since), nil
+}
+
+// formatLogs turns podman's log lines into kubelet's, with RFC3339Nano timestamps when asked for,
+// and also applies the filters that podman doesn't do for us
+func formatLogs(input io.ReadCloser, opts vkapi.ContainerLogOpts, since time.Time) io.ReadCloser {
+	outR, outW := io.Pipe()
+	go func() {
+		defer input.Close()
+		reader := bufio.NewReader(input)
+		for {
+			line, err := reader.ReadString('\n')
+			if len(line) > 0 {
+				if formatted, ok := formatLogLine(line, opts.Timestamps, since); ok {
+					if _, err := io.WriteString(outW, formatted); err != nil {
+						return
+					}
+				}
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				outW.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	var output io.Reader = outR
+	if opts.LimitBytes > 0 {
+		output = io.LimitReader(outR, int64(opts.LimitBytes))
+	}
+	return &logStream{output, []io.Closer{outR, input}}
+}
+
+func formatLogLine(line string, withTimestamp bool, since time.Time) (string, bool) {
+	parts := strings.SplitN(line, " ", 2)
+	stamp, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil || len(parts) < 2 {
+		// not something we know how to handle, so pass it along
+		return line, true
+	}
+
+	if !since.IsZero() && stamp.Before(since) {
+		return "", false
+	}
+	if withTimestamp {
+		return stamp.UTC().Format(time.RFC3339Nano) + " " + parts[1], true
+	}
+	return parts[1], true
+}
+
+// tailLines keeps the last few lines of a previous log, which is small enough to buffer
+func tailLines(input io.ReadCloser, count int) (io.ReadCloser, error) {
+	defer input.Close()
+	body, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.SplitAfter(string(body), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > count {
+		lines = lines[len(lines)-count:]
+	}
+	return ioutil.NopCloser(strings.NewReader(strings.Join(lines, ""))), nil
+}
+
+type logStream struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (ls *logStream) Close() error {
+	for _, closer := range ls.closers {
+		closer.Close()
+	}
+	return nil
 }
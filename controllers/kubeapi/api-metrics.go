@@ -0,0 +1,235 @@
+package kubeapi
+
+import (
+	"log"
+	"net/http"
+	"runtime"
+	"time"
+
+	statsv1 "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+
+	linuxproc "github.com/c9s/goprocinfo/linux"
+
+	"github.com/danopia/kube-pet-node/pkg/metrics"
+)
+
+// ServeResourceMetrics is kubelet's /metrics/resource, the small set of numbers that metrics-server wants
+func (ka *KubeApi) ServeResourceMetrics(w http.ResponseWriter, req *http.Request) {
+	summary, _, err := ka.stats.Snapshot()
+	w.Header().Set("Content-Type", metrics.ContentType)
+	mw := metrics.NewWriter(w)
+
+	mw.Family("scrape_error", "1 if there was an error while getting container metrics, 0 otherwise", metrics.Gauge)
+	if err != nil {
+		mw.Sample("scrape_error", nil, 1)
+		mw.Flush()
+		return
+	}
+	mw.Sample("scrape_error", nil, 0)
+
+	node := summary.Node
+	mw.Family("node_cpu_usage_seconds_total", "Cumulative cpu time consumed by the node in core-seconds", metrics.Counter)
+	if node.CPU != nil && node.CPU.UsageCoreNanoSeconds != nil {
+		mw.SampleAt("node_cpu_usage_seconds_total", nil, nanosToSeconds(*node.CPU.UsageCoreNanoSeconds), node.CPU.Time.Time)
+	}
+	mw.Family("node_memory_working_set_bytes", "Current working set of the node in bytes", metrics.Gauge)
+	if node.Memory != nil && node.Memory.WorkingSetBytes != nil {
+		mw.SampleAt("node_memory_working_set_bytes", nil, float64(*node.Memory.WorkingSetBytes), node.Memory.Time.Time)
+	}
+
+	mw.Family("container_cpu_usage_seconds_total", "Cumulative cpu time consumed by the container in core-seconds", metrics.Counter)
+	for _, pod := range summary.Pods {
+		for _, con := range pod.Containers {
+			if con.CPU != nil && con.CPU.UsageCoreNanoSeconds != nil {
+				mw.SampleAt("container_cpu_usage_seconds_total", resourceLabels(pod, con.Name), nanosToSeconds(*con.CPU.UsageCoreNanoSeconds), con.CPU.Time.Time)
+			}
+		}
+	}
+	mw.Family("container_memory_working_set_bytes", "Current working set of the container in bytes", metrics.Gauge)
+	for _, pod := range summary.Pods {
+		for _, con := range pod.Containers {
+			if con.Memory != nil && con.Memory.WorkingSetBytes != nil {
+				mw.SampleAt("container_memory_working_set_bytes", resourceLabels(pod, con.Name), float64(*con.Memory.WorkingSetBytes), con.Memory.Time.Time)
+			}
+		}
+	}
+
+	if err := mw.Flush(); err != nil {
+		log.Println("kubeapi WARN: Failed to write resource metrics:", err)
+	}
+}
+
+func resourceLabels(pod statsv1.PodStats, containerName string) metrics.Labels {
+	return metrics.Labels{
+		"container": containerName,
+		"pod":       pod.PodRef.Name,
+		"namespace": pod.PodRef.Namespace,
+	}
+}
+
+// cadvisorRow is one container's worth of cAdvisor series.
+// The infra container shows up as "POD", the same as dockershim's pause containers.
+type cadvisorRow struct {
+	labels metrics.Labels
+	pod    *statsv1.PodStats
+	con    *statsv1.ContainerStats // nil for the POD row
+}
+
+type cadvisorFamily struct {
+	name  string
+	help  string
+	kind  string
+	value func(row cadvisorRow) (float64, time.Time, bool)
+}
+
+// these names and labels match what cAdvisor exports, so that existing dashboards work
+var cadvisorFamilies = []cadvisorFamily{
+	{"container_cpu_usage_seconds_total", "Cumulative cpu time consumed in seconds.", metrics.Counter, func(row cadvisorRow) (float64, time.Time, bool) {
+		if row.con == nil || row.con.CPU == nil || row.con.CPU.UsageCoreNanoSeconds == nil {
+			return 0, time.Time{}, false
+		}
+		return nanosToSeconds(*row.con.CPU.UsageCoreNanoSeconds), row.con.CPU.Time.Time, true
+	}},
+	{"container_cpu_system_seconds_total", "Cumulative system cpu time consumed in seconds.", metrics.Counter, func(row cadvisorRow) (float64, time.Time, bool) {
+		return userMetric(row, "podman.cpu_system", 1e-9)
+	}},
+	{"container_memory_usage_bytes", "Current memory usage in bytes, including all memory regardless of when it was accessed", metrics.Gauge, func(row cadvisorRow) (float64, time.Time, bool) {
+		if row.con == nil || row.con.Memory == nil || row.con.Memory.UsageBytes == nil {
+			return 0, time.Time{}, false
+		}
+		return float64(*row.con.Memory.UsageBytes), row.con.Memory.Time.Time, true
+	}},
+	{"container_memory_working_set_bytes", "Current working set in bytes.", metrics.Gauge, func(row cadvisorRow) (float64, time.Time, bool) {
+		if row.con == nil || row.con.Memory == nil || row.con.Memory.WorkingSetBytes == nil {
+			return 0, time.Time{}, false
+		}
+		return float64(*row.con.Memory.WorkingSetBytes), row.con.Memory.Time.Time, true
+	}},
+	{"container_spec_memory_limit_bytes", "Memory limit for the container.", metrics.Gauge, func(row cadvisorRow) (float64, time.Time, bool) {
+		if row.con == nil || row.con.Memory == nil || row.con.Memory.UsageBytes == nil || row.con.Memory.AvailableBytes == nil {
+			return 0, time.Time{}, false
+		}
+		return float64(*row.con.Memory.UsageBytes + *row.con.Memory.AvailableBytes), row.con.Memory.Time.Time, true
+	}},
+	{"container_processes", "Number of processes running inside the container.", metrics.Gauge, func(row cadvisorRow) (float64, time.Time, bool) {
+		return userMetric(row, "podman.pids", 1)
+	}},
+	{"container_fs_reads_bytes_total", "Cumulative count of bytes read", metrics.Counter, func(row cadvisorRow) (float64, time.Time, bool) {
+		return userMetric(row, "podman.block_input", 1)
+	}},
+	{"container_fs_writes_bytes_total", "Cumulative count of bytes written", metrics.Counter, func(row cadvisorRow) (float64, time.Time, bool) {
+		return userMetric(row, "podman.block_output", 1)
+	}},
+	{"container_fs_usage_bytes", "Number of bytes that are consumed by the container on this filesystem.", metrics.Gauge, func(row cadvisorRow) (float64, time.Time, bool) {
+		if row.con == nil || row.con.Rootfs == nil || row.con.Rootfs.UsedBytes == nil {
+			return 0, time.Time{}, false
+		}
+		return float64(*row.con.Rootfs.UsedBytes), row.con.Rootfs.Time.Time, true
+	}},
+	{"container_fs_limit_bytes", "Number of bytes that can be consumed by the container on this filesystem.", metrics.Gauge, func(row cadvisorRow) (float64, time.Time, bool) {
+		if row.con == nil || row.con.Rootfs == nil || row.con.Rootfs.CapacityBytes == nil {
+			return 0, time.Time{}, false
+		}
+		return float64(*row.con.Rootfs.CapacityBytes), row.con.Rootfs.Time.Time, true
+	}},
+	{"container_network_receive_bytes_total", "Cumulative count of bytes received", metrics.Counter, func(row cadvisorRow) (float64, time.Time, bool) {
+		if row.con != nil || row.pod.Network == nil || row.pod.Network.RxBytes == nil {
+			return 0, time.Time{}, false
+		}
+		return float64(*row.pod.Network.RxBytes), row.pod.Network.Time.Time, true
+	}},
+	{"container_network_transmit_bytes_total", "Cumulative count of bytes transmitted", metrics.Counter, func(row cadvisorRow) (float64, time.Time, bool) {
+		if row.con != nil || row.pod.Network == nil || row.pod.Network.TxBytes == nil {
+			return 0, time.Time{}, false
+		}
+		return float64(*row.pod.Network.TxBytes), row.pod.Network.Time.Time, true
+	}},
+}
+
+// ServeCadvisorMetrics is kubelet's /metrics/cadvisor, built from the same samples as the stats summary
+func (ka *KubeApi) ServeCadvisorMetrics(w http.ResponseWriter, req *http.Request) {
+	summary, containers, err := ka.stats.Snapshot()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	rows := make([]cadvisorRow, 0, len(containers))
+	for idx := range summary.Pods {
+		pod := &summary.Pods[idx]
+		if meta, ok := containers[containerKey{pod.PodRef.UID, "_infra"}]; ok {
+			rows = append(rows, cadvisorRow{cadvisorLabels(pod, "POD", meta), pod, nil})
+		}
+		for conIdx := range pod.Containers {
+			con := &pod.Containers[conIdx]
+			meta := containers[containerKey{pod.PodRef.UID, con.Name}]
+			rows = append(rows, cadvisorRow{cadvisorLabels(pod, con.Name, meta), pod, con})
+		}
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	mw := metrics.NewWriter(w)
+
+	for _, family := range cadvisorFamilies {
+		mw.Family(family.name, family.help, family.kind)
+		for _, row := range rows {
+			labels := row.labels
+			if family.name == "container_cpu_usage_seconds_total" {
+				labels = withLabel(labels, "cpu", "total")
+			} else if family.name == "container_network_receive_bytes_total" || family.name == "container_network_transmit_bytes_total" {
+				labels = withLabel(labels, "interface", "eth0")
+			}
+			if value, stamp, ok := family.value(row); ok {
+				mw.SampleAt(family.name, labels, value, stamp)
+			}
+		}
+	}
+
+	mw.Family("machine_cpu_cores", "Number of logical CPU cores.", metrics.Gauge)
+	mw.Sample("machine_cpu_cores", nil, float64(runtime.NumCPU()))
+	if memInfo, err := linuxproc.ReadMemInfo("/proc/meminfo"); err == nil {
+		mw.Family("machine_memory_bytes", "Amount of memory installed on the machine.", metrics.Gauge)
+		mw.Sample("machine_memory_bytes", nil, float64(memInfo.MemTotal*1024))
+	}
+
+	if err := mw.Flush(); err != nil {
+		log.Println("kubeapi WARN: Failed to write cadvisor metrics:", err)
+	}
+}
+
+func cadvisorLabels(pod *statsv1.PodStats, containerName string, meta containerMeta) metrics.Labels {
+	return metrics.Labels{
+		"container": containerName,
+		"pod":       pod.PodRef.Name,
+		"namespace": pod.PodRef.Namespace,
+		"image":     meta.image,
+		"name":      meta.name,
+		"id":        "/kube-pet/pod" + pod.PodRef.UID + "/" + meta.id,
+	}
+}
+
+func withLabel(labels metrics.Labels, name, value string) metrics.Labels {
+	extended := make(metrics.Labels, len(labels)+1)
+	for k, v := range labels {
+		extended[k] = v
+	}
+	extended[name] = value
+	return extended
+}
+
+// userMetric digs one of our podman.* numbers back out of a container's stats
+func userMetric(row cadvisorRow, name string, scale float64) (float64, time.Time, bool) {
+	if row.con == nil {
+		return 0, time.Time{}, false
+	}
+	for _, metric := range row.con.UserDefinedMetrics {
+		if metric.Name == name {
+			return metric.Value * scale, metric.Time.Time, true
+		}
+	}
+	return 0, time.Time{}, false
+}
+
+func nanosToSeconds(nanos uint64) float64 {
+	return float64(nanos) / 1e9
+}
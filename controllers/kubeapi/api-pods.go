@@ -0,0 +1,76 @@
+package kubeapi
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/danopia/kube-pet-node/controllers/pods"
+)
+
+// GetPods is kubelet's /pods, every pod that we've been told to run
+func (ka *KubeApi) GetPods(ctx context.Context) ([]*corev1.Pod, error) {
+	knownPods := ka.podManager.SnapshotPods()
+	list := make([]*corev1.Pod, 0, len(knownPods))
+	for _, pod := range knownPods {
+		if pod.Kube != nil {
+			list = append(list, pod.Kube.DeepCopy())
+		}
+	}
+	return list, nil
+}
+
+// GetRunningPods is kubelet's /runningpods, built from what podman actually has running.
+// Like kubelet, only the identity and containers are filled in.
+func (ka *KubeApi) GetRunningPods(ctx context.Context) ([]*corev1.Pod, error) {
+	foundPods, err := ka.podManager.GetPodman().PodPs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*corev1.Pod, 0, len(foundPods))
+	for _, foundPod := range foundPods {
+		if foundPod.Labels["heritage"] != "kube-pet-node" {
+			continue
+		}
+		podCoord, ok := pods.ParsePodKey(foundPod.Name)
+		if !ok {
+			continue
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: podCoord.Namespace,
+				Name:      podCoord.Name,
+			},
+		}
+		known, isKnown := ka.podManager.GetPod(podCoord.Key())
+		if isKnown && known.Kube != nil {
+			pod.ObjectMeta.UID = known.Kube.ObjectMeta.UID
+		}
+
+		for _, container := range foundPod.Containers {
+			// the infra container doesn't have a kube name
+			nameParts := strings.Split(container.Names, "_")
+			if len(nameParts) < 3 || container.Status != "running" {
+				continue
+			}
+			conSpec := corev1.Container{Name: nameParts[2]}
+			if isKnown && known.Kube != nil {
+				for _, specCon := range known.Kube.Spec.Containers {
+					if specCon.Name == conSpec.Name {
+						conSpec.Image = specCon.Image
+					}
+				}
+			}
+			pod.Spec.Containers = append(pod.Spec.Containers, conSpec)
+		}
+
+		if len(pod.Spec.Containers) > 0 {
+			list = append(list, pod)
+		}
+	}
+	return list, nil
+}
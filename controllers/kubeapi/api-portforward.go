@@ -0,0 +1,165 @@
+package kubeapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/kubernetes/pkg/kubelet/server/portforward"
+
+	"github.com/danopia/kube-pet-node/controllers/pods"
+)
+
+// ServePortForward handles /portForward/{namespace}/{pod}, which virtual-kubelet doesn't route either.
+// Each forwarded connection shows up as a call to PortForward.
+func (ka *KubeApi) ServePortForward(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/portForward/"), "/")
+	var podUID types.UID
+	switch len(parts) {
+	case 2:
+	case 3:
+		podUID = types.UID(parts[2])
+	default:
+		http.Error(w, "expected /portForward/{namespace}/{pod}", http.StatusNotFound)
+		return
+	}
+	podCoord := pods.PodCoord{Namespace: parts[0], Name: parts[1]}
+
+	// only used for websocket clients, SPDY clients say their ports per stream instead
+	forwardOpts, err := portforward.NewV4Options(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	portforward.ServePortForward(w, req, portForwarderFunc(func(name string, uid types.UID, port int32, stream io.ReadWriteCloser) error {
+		return ka.PortForward(req.Context(), podCoord, port, stream)
+	}), podCoord.Key(), podUID, forwardOpts, streamIdleTimeout, remotecommandconsts.DefaultStreamCreationTimeout, portforward.SupportedProtocols)
+}
+
+type portForwarderFunc func(name string, uid types.UID, port int32, stream io.ReadWriteCloser) error
+
+func (pf portForwarderFunc) PortForward(name string, uid types.UID, port int32, stream io.ReadWriteCloser) error {
+	return pf(name, uid, port, stream)
+}
+
+// PortForward connects one client stream to a port in the pod.
+// Whatever we return gets written to the client's error stream.
+func (ka *KubeApi) PortForward(ctx context.Context, podCoord pods.PodCoord, port int32, stream io.ReadWriteCloser) error {
+	log.Println("PortForward(", podCoord, port, ")")
+	defer stream.Close()
+
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port %d", port)
+	}
+
+	// the host pod and hostNetwork pods share our network namespace, so localhost is theirs too
+	if ka.isHostPod(podCoord.Namespace, podCoord.Name) {
+		return forwardToAddress(ctx, stream, net.JoinHostPort("localhost", strconv.Itoa(int(port))))
+	}
+	known, ok := ka.podManager.GetPod(podCoord.Key())
+	if !ok || known.Kube == nil {
+		return fmt.Errorf("pod %v not found", podCoord)
+	}
+	if known.Kube.Spec.HostNetwork {
+		return forwardToAddress(ctx, stream, net.JoinHostPort("localhost", strconv.Itoa(int(port))))
+	}
+
+	podIP, netnsPid, err := ka.podManager.InspectPodNetwork(ctx, podCoord)
+	if err != nil {
+		return err
+	}
+
+	// the pod IP is the cheap way in, but it doesn't reach things that only listen on loopback
+	if podIP != "" {
+		err := forwardToAddress(ctx, stream, net.JoinHostPort(podIP, strconv.Itoa(int(port))))
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			return err
+		}
+		log.Println("kubeapi: Nothing listening on", podIP, "port", port, "- trying inside", podCoord, "instead")
+	}
+	return ka.forwardInNetns(ctx, stream, netnsPid, port)
+}
+
+func forwardToAddress(ctx context.Context, stream io.ReadWriteCloser, address string) error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	go func() {
+		io.Copy(conn, stream)
+		// let the other side know that the client is done sending
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.CloseWrite()
+		}
+	}()
+	if _, err := io.Copy(stream, conn); err != nil && !isClosedErr(err) {
+		return err
+	}
+	return nil
+}
+
+// installed by our packages, see gradle/dist/pod-port-forward.sh
+const podPortForwardScript = "/opt/kube-pet-node/bin/pod-port-forward.sh"
+
+// forwardInNetns reaches the pod's loopback by running socat inside the pod's network namespace,
+// the same way kubelet's dockershim does it
+func (ka *KubeApi) forwardInNetns(ctx context.Context, stream io.ReadWriteCloser, netnsPid int, port int32) error {
+	if netnsPid <= 0 {
+		return fmt.Errorf("pod's network namespace isn't available")
+	}
+
+	var proc *exec.Cmd
+	if ka.rootless {
+		// rootless pods live in our own user namespace, so we can just join it
+		proc = exec.CommandContext(ctx, "nsenter", "--target", strconv.Itoa(netnsPid), "--user", "--preserve-credentials",
+			"--net", "--", "socat", "-", "TCP:localhost:"+strconv.Itoa(int(port)))
+	} else if os.Getuid() == 0 {
+		proc = exec.CommandContext(ctx, "nsenter", "--target", strconv.Itoa(netnsPid),
+			"--net", "--", "socat", "-", "TCP:localhost:"+strconv.Itoa(int(port)))
+	} else {
+		// sudoers only lets us run nsenter through a script that checks the PID and port
+		proc = exec.CommandContext(ctx, "sudo", podPortForwardScript, strconv.Itoa(netnsPid), strconv.Itoa(int(port)))
+	}
+
+	stdin, err := proc.StdinPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	proc.Stdout = stream
+	proc.Stderr = &stderr
+
+	if err := proc.Start(); err != nil {
+		return err
+	}
+	go func() {
+		io.Copy(stdin, stream)
+		stdin.Close()
+	}()
+
+	if err := proc.Wait(); err != nil {
+		return fmt.Errorf("port %d inside pod: %v: %s", port, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func isClosedErr(err error) bool {
+	return strings.Contains(err.Error(), "use of closed network connection")
+}
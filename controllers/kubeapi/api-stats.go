@@ -2,7 +2,9 @@ package kubeapi
 
 import (
 	"context"
+	"encoding/json"
 	"log"
+	"net/http"
 	"strings"
 	"time"
 
@@ -15,16 +17,86 @@ import (
 )
 
 func (ka *KubeApi) GetStatsSummary(ctx context.Context) (*statsv1.Summary, error) {
+	return ka.stats.Summary()
+}
+
+// The parts of Kubernetes 1.19's summary which our stats types predate, spelled the same in JSON
+type processStats struct {
+	ProcessCount *uint64 `json:"process_count,omitempty"`
+}
+
+type podStatsWithProcesses struct {
+	statsv1.PodStats
+	ProcessStats *processStats `json:"process_stats,omitempty"`
+}
+
+type summaryWithProcesses struct {
+	Node statsv1.NodeStats       `json:"node"`
+	Pods []podStatsWithProcesses `json:"pods"`
+}
+
+// ServeStatsSummary is kubelet's /stats/summary, served by us instead of virtual-kubelet
+// so that each pod can say how many processes it's running
+func (ka *KubeApi) ServeStatsSummary(w http.ResponseWriter, req *http.Request) {
+	summary, containers, err := ka.stats.Snapshot()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	processCounts := make(map[string]uint64, len(summary.Pods))
+	for key, meta := range containers {
+		processCounts[key.podUID] += meta.pids
+	}
+
+	output := summaryWithProcesses{
+		Node: summary.Node,
+		Pods: make([]podStatsWithProcesses, len(summary.Pods)),
+	}
+	for idx, pod := range summary.Pods {
+		output.Pods[idx].PodStats = pod
+		if count, ok := processCounts[pod.PodRef.UID]; ok {
+			output.Pods[idx].ProcessStats = &processStats{ProcessCount: &count}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(output); err != nil {
+		log.Println("kubeapi WARN: Failed to write stats summary:", err)
+	}
+}
+
+// buildSummary takes a new sample of everything, keeping the CPU counters for next time
+func (sc *StatsCollector) buildSummary(ctx context.Context) (*statsv1.Summary, map[containerKey]containerMeta, error) {
 
 	// the actual API call part
 	nowStamp := metav1.NewTime(time.Now())
-	podReports, err := ka.podManager.GetAllStats(ctx)
+	podReports, err := sc.podManager.GetAllStats(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	containerIDs := make([]string, 0, len(sc.conHistory))
+	for _, reports := range podReports {
+		for _, report := range reports {
+			containerIDs = append(containerIDs, report.ContainerID)
+		}
+	}
+	sc.refreshFsUsage(ctx, containerIDs)
+	fsStamp := metav1.NewTime(sc.fsUsage.time)
+
+	// container layers, logs, and volumes all end up in podman's storage
+	var imageFs *statsv1.FsStats
+	if sc.fsUsage.graphRoot != "" {
+		if imageFs, err = statfsStats(sc.fsUsage.graphRoot, nowStamp); err != nil {
+			log.Println("kubeapi WARN: Failed to statfs podman storage:", err.Error())
+		}
 	}
 
-	// generally, the total number of containers we are running will be quite stable
-	newStats := make(map[string]prevStat, len(ka.prevStats))
+	containers := make(map[containerKey]containerMeta, len(containerIDs))
+
+	// forget about containers that went away
+	seenContainers := make(map[string]struct{}, len(sc.conHistory))
 
 	podStats := make([]statsv1.PodStats, 0, len(podReports))
 	for podMeta, reports := range podReports {
@@ -33,8 +105,10 @@ func (ka *KubeApi) GetStatsSummary(ctx context.Context) (*statsv1.Summary, error
 		}
 
 		var totalNanoCores uint64
+		var hasNanoCores bool
 		var totalCPUTime uint64
 		var totalMem uint64
+		var totalEphemeral uint64
 
 		var netStats *statsv1.NetworkStats
 
@@ -42,22 +116,33 @@ func (ka *KubeApi) GetStatsSummary(ctx context.Context) (*statsv1.Summary, error
 		for conName, report := range reports {
 			reportTime := metav1.NewTime(time.Unix(0, int64(report.SystemNano)))
 
-			newStats[report.ContainerID] = prevStat{
-				time: report.SystemNano, cpu: report.CPUNano,
+			history, ok := sc.conHistory[report.ContainerID]
+			if !ok {
+				history = &cpuHistory{}
+				sc.conHistory[report.ContainerID] = history
+			}
+			history.Add(cpuSample{time: report.SystemNano, cpu: report.CPUNano})
+			seenContainers[report.ContainerID] = struct{}{}
+			containers[containerKey{string(podMeta.UID), conName}] = containerMeta{
+				id:    report.ContainerID,
+				name:  report.Name,
+				image: sc.fsUsage.imageNames[report.ContainerID],
+				pids:  report.PIDs,
 			}
-			prevStat, hasPrevStat := ka.prevStats[report.ContainerID]
 
 			memUsed := report.MemUsage
 			memAvail := (report.MemLimit - report.MemUsage)
 
-			cpuFrac := 0.0
-			if hasPrevStat {
-				cpuFrac = calculateCPUFraction(report.CPUNano, prevStat.cpu, report.SystemNano, prevStat.time)
+			// the rate is left out until we have two samples, like cadvisor does
+			var cpuNano *uint64
+			if cpuFrac, ok := history.Rate(); ok {
+				rate := uint64(cpuFrac * 1000 * 1000 * 1000)
+				cpuNano = &rate
+				totalNanoCores += rate
+				hasNanoCores = true
 			}
-			cpuNano := uint64(cpuFrac * 1000 * 1000 * 1000)
 			cpuCumulative := report.CPUNano
 
-			totalNanoCores += cpuNano
 			totalCPUTime += report.CPUNano
 			totalMem += memUsed
 
@@ -77,6 +162,14 @@ func (ka *KubeApi) GetStatsSummary(ctx context.Context) (*statsv1.Summary, error
 				}
 			}
 
+			rootfsBytes := sc.fsUsage.rootfs[report.ContainerID]
+			totalEphemeral += rootfsBytes
+			var logStats *statsv1.FsStats
+			if logBytes, ok := sc.fsUsage.logs[report.ContainerID]; ok {
+				totalEphemeral += logBytes
+				logStats = usageOn(imageFs, logBytes, fsStamp)
+			}
+
 			// Don't return infra containers themselves
 			if conName != "_infra" {
 				conStats = append(conStats, statsv1.ContainerStats{
@@ -85,7 +178,7 @@ func (ka *KubeApi) GetStatsSummary(ctx context.Context) (*statsv1.Summary, error
 					StartTime: reportTime,
 					CPU: &statsv1.CPUStats{
 						Time:                 reportTime,
-						UsageNanoCores:       &cpuNano,
+						UsageNanoCores:       cpuNano,
 						UsageCoreNanoSeconds: &cpuCumulative,
 					},
 					Memory: &statsv1.MemoryStats{
@@ -95,6 +188,8 @@ func (ka *KubeApi) GetStatsSummary(ctx context.Context) (*statsv1.Summary, error
 						WorkingSetBytes: &memUsed,
 						// RSSBytes:        &memUsed,
 					},
+					Rootfs: usageOn(imageFs, rootfsBytes, fsStamp),
+					Logs:   logStats,
 					UserDefinedMetrics: []statsv1.UserDefinedMetric{{
 						Time: reportTime,
 						UserDefinedMetricDescriptor: statsv1.UserDefinedMetricDescriptor{
@@ -132,6 +227,14 @@ func (ka *KubeApi) GetStatsSummary(ctx context.Context) (*statsv1.Summary, error
 			}
 		}
 
+		volStats, volBytes := sc.podVolumeStats(string(podMeta.UID), imageFs, fsStamp)
+		totalEphemeral += volBytes
+
+		var podNanoCores *uint64
+		if hasNanoCores {
+			podNanoCores = &totalNanoCores
+		}
+
 		podStats = append(podStats, statsv1.PodStats{
 			PodRef: statsv1.PodReference{
 				Name:      podMeta.Name,
@@ -142,7 +245,7 @@ func (ka *KubeApi) GetStatsSummary(ctx context.Context) (*statsv1.Summary, error
 			Containers: conStats,
 			CPU: &statsv1.CPUStats{
 				Time:                 nowStamp,
-				UsageNanoCores:       &totalNanoCores,
+				UsageNanoCores:       podNanoCores,
 				UsageCoreNanoSeconds: &totalCPUTime,
 			},
 			Memory: &statsv1.MemoryStats{
@@ -152,28 +255,26 @@ func (ka *KubeApi) GetStatsSummary(ctx context.Context) (*statsv1.Summary, error
 				WorkingSetBytes: &totalMem,
 				// RSSBytes:        &totalMem,
 			},
-			Network: netStats,
-			// EphemeralStorage: &statsv1.FsStats{
-			// 	Time:           nowStamp,
-			// 	AvailableBytes: &zero,
-			// 	CapacityBytes:  &zero,
-			// 	UsedBytes:      &zero,
-			// },
-			// ProcessStats: &statsv1.ProcessStats{
-			// 	ProcessCOunt: &zero,
-			// },
+			Network:          netStats,
+			VolumeStats:      volStats,
+			EphemeralStorage: usageOn(imageFs, totalEphemeral, fsStamp),
+			// ProcessStats isn't in these stats types, ServeStatsSummary adds it
 		})
 	}
 
-	ka.prevStats = newStats
+	for containerID := range sc.conHistory {
+		if _, ok := seenContainers[containerID]; !ok {
+			delete(sc.conHistory, containerID)
+		}
+	}
 
 	nodeStats := statsv1.NodeStats{
-		NodeName:  ka.nodeName,
+		NodeName:  sc.nodeName,
 		StartTime: nowStamp,
 	}
 
 	if stat, err := linuxproc.ReadStat("/proc/stat"); err != nil {
-		log.Println("KubeAPI WARN: Failed to read /proc/stat:", err.Error())
+		log.Println("kubeapi WARN: Failed to read /proc/stat:", err.Error())
 	} else {
 		clktck, err := sysconf.Sysconf(sysconf.SC_CLK_TCK)
 		if err != nil {
@@ -185,24 +286,23 @@ func (ka *KubeApi) GetStatsSummary(ctx context.Context) (*statsv1.Summary, error
 
 		nowNano := uint64(time.Now().UnixNano())
 
-		cpuFrac := 0.0
-		if ka.prevNodeStat != nil {
-			cpuFrac = calculateCPUFraction(cpuCumulative, ka.prevNodeStat.cpu, nowNano, ka.prevNodeStat.time)
+		sc.nodeHistory.Add(cpuSample{time: nowNano, cpu: cpuCumulative})
+		var cpuNano *uint64
+		if cpuFrac, ok := sc.nodeHistory.Rate(); ok {
+			rate := uint64(cpuFrac * 1000 * 1000 * 1000)
+			cpuNano = &rate
 		}
-		cpuNano := uint64(cpuFrac * 1000 * 1000 * 1000)
-
-		ka.prevNodeStat = &prevStat{cpu: cpuCumulative, time: nowNano}
 
 		nodeStats.CPU = &statsv1.CPUStats{
 			Time:                 nowStamp,
-			UsageNanoCores:       &cpuNano,
+			UsageNanoCores:       cpuNano,
 			UsageCoreNanoSeconds: &cpuCumulative,
 		}
 	}
 
 	// TODO: cache memory info for 60 seconds or so probably
 	if stat, err := linuxproc.ReadMemInfo("/proc/meminfo"); err != nil {
-		log.Println("KubeAPI WARN: Failed to read /proc/meminfo:", err.Error())
+		log.Println("kubeapi WARN: Failed to read /proc/meminfo:", err.Error())
 	} else {
 		var availBytes uint64 = stat.MemAvailable * 1024
 		var usedBytes uint64 = (stat.MemTotal - stat.MemFree) * 1024
@@ -217,7 +317,7 @@ func (ka *KubeApi) GetStatsSummary(ctx context.Context) (*statsv1.Summary, error
 		}
 
 		if vmStat, err := linuxproc.ReadVMStat("/proc/vmstat"); err != nil {
-			log.Println("KubeAPI WARN: Failed to read /proc/vmstat:", err.Error())
+			log.Println("kubeapi WARN: Failed to read /proc/vmstat:", err.Error())
 		} else {
 			nodeStats.Memory.PageFaults = &vmStat.PageFault
 			nodeStats.Memory.MajorPageFaults = &vmStat.PageMajorFault
@@ -225,7 +325,7 @@ func (ka *KubeApi) GetStatsSummary(ctx context.Context) (*statsv1.Summary, error
 	}
 
 	if statList, err := linuxproc.ReadNetworkStat("/proc/net/dev"); err != nil {
-		log.Println("KubeAPI WARN: Failed to read /proc/net/dev:", err.Error())
+		log.Println("kubeapi WARN: Failed to read /proc/net/dev:", err.Error())
 	} else {
 		netStats := make([]statsv1.InterfaceStats, 0, len(statList))
 		for _, stat := range statList {
@@ -257,11 +357,32 @@ func (ka *KubeApi) GetStatsSummary(ctx context.Context) (*statsv1.Summary, error
 
 	}
 
+	if rootFs, err := statfsStats("/", nowStamp); err != nil {
+		log.Println("kubeapi WARN: Failed to statfs the root filesystem:", err.Error())
+	} else {
+		nodeStats.Fs = rootFs
+	}
+
+	if imageFs != nil {
+		imagesFs := *imageFs
+		imagesFs.UsedBytes = &sc.fsUsage.images
+		imagesFs.Time = fsStamp
+		nodeStats.Runtime = &statsv1.RuntimeStats{
+			ImageFs: &imagesFs,
+		}
+	}
+
+	if rlimit, err := readRlimitStats(nowStamp); err != nil {
+		log.Println("kubeapi WARN: Failed to count processes:", err.Error())
+	} else {
+		nodeStats.Rlimit = rlimit
+	}
+
 	// https://godoc.org/k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1#Summary
 	return &statsv1.Summary{
 		Node: nodeStats,
 		Pods: podStats,
-	}, nil
+	}, containers, nil
 }
 
 // https://github.com/containers/podman/blob/3569e24df8c3f774def37d99b7e23158349e92cf/libpod/stats.go#L102
@@ -0,0 +1,226 @@
+package kubeapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AuthOptions controls who can use the kubelet API on port 10250
+type AuthOptions struct {
+	// ClientCAs verifies client certificates, usually the cluster's CA.
+	// Without it, only bearer tokens are accepted.
+	ClientCAs *x509.CertPool
+	// Anonymous lets requests without any credentials through as system:anonymous.
+	// They still need to be authorized like everyone else.
+	Anonymous bool
+}
+
+// same as kubelet's defaults for webhook authn/authz
+const (
+	authnCacheTTL        = 2 * time.Minute
+	authnFailureCacheTTL = 10 * time.Second
+	authzAllowedCacheTTL = 5 * time.Minute
+	authzDeniedCacheTTL  = 30 * time.Second
+)
+
+// authFilter checks every request against the API server, the way kubelet's webhook mode does
+func (ka *KubeApi) authFilter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user, err := ka.authenticate(req)
+		if err != nil {
+			log.Println("kubeapi WARN: Failed to authenticate", req.Method, req.URL.Path, "from", req.RemoteAddr, ":", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		verb, subresource := requestAttributes(req)
+		allowed, reason, err := ka.authorize(req.Context(), user, verb, subresource)
+		if err != nil {
+			log.Println("kubeapi WARN: Failed to authorize", user.Username, "for", verb, "nodes/"+subresource, ":", err)
+			http.Error(w, fmt.Sprintf("Authorization error (user=%s, verb=%s, resource=nodes, subresource=%s)", user.Username, verb, subresource), http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			log.Println("kubeapi: Denied", user.Username, "for", verb, "nodes/"+subresource, reason)
+			http.Error(w, fmt.Sprintf("Forbidden (user=%s, verb=%s, resource=nodes, subresource=%s)", user.Username, verb, subresource), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (ka *KubeApi) authenticate(req *http.Request) (*authenticationv1.UserInfo, error) {
+	// the TLS handshake already checked these against ClientCAs
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 && len(req.TLS.VerifiedChains[0]) > 0 {
+		cert := req.TLS.VerifiedChains[0][0]
+		groups := make([]string, 0, len(cert.Subject.Organization)+1)
+		groups = append(groups, cert.Subject.Organization...)
+		return &authenticationv1.UserInfo{
+			Username: cert.Subject.CommonName,
+			Groups:   append(groups, "system:authenticated"),
+		}, nil
+	}
+
+	if authz := req.Header.Get("Authorization"); strings.HasPrefix(authz, "Bearer ") {
+		return ka.reviewToken(req.Context(), strings.TrimSpace(strings.TrimPrefix(authz, "Bearer ")))
+	}
+
+	if ka.authOpts.Anonymous {
+		return &authenticationv1.UserInfo{
+			Username: "system:anonymous",
+			Groups:   []string{"system:unauthenticated"},
+		}, nil
+	}
+	return nil, fmt.Errorf("no credentials given")
+}
+
+func (ka *KubeApi) reviewToken(ctx context.Context, token string) (*authenticationv1.UserInfo, error) {
+	tokenHash := sha256.Sum256([]byte(token))
+	cacheKey := hex.EncodeToString(tokenHash[:])
+	if cached, ok := ka.authnCache.Get(cacheKey); ok {
+		if cached == nil {
+			return nil, fmt.Errorf("token was recently rejected")
+		}
+		return cached.(*authenticationv1.UserInfo), nil
+	}
+
+	review, err := ka.kubernetes.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token: token,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if !review.Status.Authenticated {
+		ka.authnCache.Set(cacheKey, nil, authnFailureCacheTTL)
+		return nil, fmt.Errorf("token rejected: %s", review.Status.Error)
+	}
+	user := review.Status.User
+	ka.authnCache.Set(cacheKey, &user, authnCacheTTL)
+	return &user, nil
+}
+
+func (ka *KubeApi) authorize(ctx context.Context, user *authenticationv1.UserInfo, verb, subresource string) (bool, string, error) {
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	spec := authorizationv1.SubjectAccessReviewSpec{
+		ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Verb:        verb,
+			Resource:    "nodes",
+			Subresource: subresource,
+			Name:        ka.nodeName,
+		},
+		User:   user.Username,
+		UID:    user.UID,
+		Groups: user.Groups,
+		Extra:  extra,
+	}
+
+	keyBytes, err := json.Marshal(&spec)
+	if err != nil {
+		return false, "", err
+	}
+	cacheKey := string(keyBytes)
+	if cached, ok := ka.authzCache.Get(cacheKey); ok {
+		status := cached.(authorizationv1.SubjectAccessReviewStatus)
+		return status.Allowed, status.Reason, nil
+	}
+
+	review, err := ka.kubernetes.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: spec,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", err
+	}
+
+	if review.Status.Allowed {
+		ka.authzCache.Set(cacheKey, review.Status, authzAllowedCacheTTL)
+	} else {
+		ka.authzCache.Set(cacheKey, review.Status, authzDeniedCacheTTL)
+	}
+	return review.Status.Allowed, review.Status.Reason, nil
+}
+
+// requestAttributes maps a request onto the node subresource that kubelet would check
+func requestAttributes(req *http.Request) (verb, subresource string) {
+	switch req.Method {
+	case "POST":
+		verb = "create"
+	case "GET", "HEAD":
+		verb = "get"
+	case "PUT":
+		verb = "update"
+	case "PATCH":
+		verb = "patch"
+	case "DELETE":
+		verb = "delete"
+	default:
+		verb = strings.ToLower(req.Method)
+	}
+
+	subresource = "proxy"
+	for _, prefix := range []string{"stats", "metrics", "logs", "spec"} {
+		if req.URL.Path == "/"+prefix || strings.HasPrefix(req.URL.Path, "/"+prefix+"/") {
+			subresource = prefix
+		}
+	}
+	if subresource == "logs" {
+		subresource = "log"
+	}
+	return
+}
+
+// authCache remembers review results for a while, so that a busy scraper doesn't hammer the API server
+type authCache struct {
+	lock    sync.Mutex
+	entries map[string]authCacheEntry
+}
+
+type authCacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func (ac *authCache) Get(key string) (interface{}, bool) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	entry, ok := ac.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (ac *authCache) Set(key string, value interface{}, ttl time.Duration) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	if ac.entries == nil {
+		ac.entries = make(map[string]authCacheEntry)
+	}
+
+	now := time.Now()
+	for oldKey, entry := range ac.entries {
+		if now.After(entry.expires) {
+			delete(ac.entries, oldKey)
+		}
+	}
+	ac.entries[key] = authCacheEntry{value, now.Add(ttl)}
+}
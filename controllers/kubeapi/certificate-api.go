@@ -0,0 +1,89 @@
+package kubeapi
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	certv1 "k8s.io/api/certificates/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// csrClient is the part of the CSR API that we use, from whichever version the cluster serves.
+// Our client-go predates certificates.k8s.io/v1, so that version is spoken with raw requests;
+// the v1beta1 types serialize the same way for every field that we touch.
+type csrClient interface {
+	Create(ctx context.Context, csr *certv1.CertificateSigningRequest, opts metav1.CreateOptions) (*certv1.CertificateSigningRequest, error)
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*certv1.CertificateSigningRequest, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*certv1.CertificateSigningRequestList, error)
+}
+
+const csrV1Path = "/apis/certificates.k8s.io/v1/certificatesigningrequests"
+
+// newCsrClient prefers certificates.k8s.io/v1, which newer clusters only have
+func newCsrClient(kubernetes *kubernetes.Clientset) (csrClient, error) {
+	_, err := kubernetes.Discovery().ServerResourcesForGroupVersion("certificates.k8s.io/v1")
+	if errors.IsNotFound(err) {
+		log.Println("Cluster doesn't have certificates.k8s.io/v1, using v1beta1 instead")
+		return kubernetes.CertificatesV1beta1().CertificateSigningRequests(), nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &csrV1Client{kubernetes.CertificatesV1beta1().RESTClient()}, nil
+}
+
+type csrV1Client struct {
+	client rest.Interface
+}
+
+func (c *csrV1Client) Create(ctx context.Context, csr *certv1.CertificateSigningRequest, opts metav1.CreateOptions) (*certv1.CertificateSigningRequest, error) {
+	body := csr.DeepCopy()
+	body.TypeMeta = metav1.TypeMeta{
+		APIVersion: "certificates.k8s.io/v1",
+		Kind:       "CertificateSigningRequest",
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := c.client.Post().
+		AbsPath(csrV1Path).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		SetHeader("Content-Type", "application/json").
+		Body(data).
+		Do(ctx).Raw()
+	if err != nil {
+		return nil, err
+	}
+	var result certv1.CertificateSigningRequest
+	return &result, json.Unmarshal(raw, &result)
+}
+
+func (c *csrV1Client) Get(ctx context.Context, name string, opts metav1.GetOptions) (*certv1.CertificateSigningRequest, error) {
+	raw, err := c.client.Get().
+		AbsPath(csrV1Path, name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Do(ctx).Raw()
+	if err != nil {
+		return nil, err
+	}
+	var result certv1.CertificateSigningRequest
+	return &result, json.Unmarshal(raw, &result)
+}
+
+func (c *csrV1Client) List(ctx context.Context, opts metav1.ListOptions) (*certv1.CertificateSigningRequestList, error) {
+	raw, err := c.client.Get().
+		AbsPath(csrV1Path).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Do(ctx).Raw()
+	if err != nil {
+		return nil, err
+	}
+	var result certv1.CertificateSigningRequestList
+	return &result, json.Unmarshal(raw, &result)
+}
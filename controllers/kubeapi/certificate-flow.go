@@ -2,88 +2,164 @@ package kubeapi
 
 import (
 	"context"
+	"crypto"
+	"crypto/x509/pkix"
+	"fmt"
 	"log"
-	"os/exec"
-	"strings"
 	"time"
 
 	certv1 "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func (ka *KubeApi) PerformCertificateFlow(ctx context.Context) error {
-	csrApi := ka.kubernetes.CertificatesV1beta1().CertificateSigningRequests()
-	csrName := "kube-pet-node." + ka.nodeName
+// CSRs we make are labelled with our node, so we can find them again after a restart
+const csrNodeLabel = "kube-pet-node/node"
 
-	err := ka.keyStorage.EnsurePrivateKeyExists(func(keyPath string) error {
-		log.Println("Generating new RSA private key at", keyPath)
-		return exec.Command("openssl", "genrsa", "-out", keyPath, "2048").Run()
-	})
+// only certificates.k8s.io/v1 has this, for signers which give up
+const csrConditionFailed certv1.RequestConditionType = "Failed"
+
+// warn about a CSR that nobody has approved for this long
+const csrPendingWarning = 15 * time.Minute
+
+// csrFlow gets one of our keys signed through the certificates API
+type csrFlow struct {
+	csrApi     csrClient
+	keyStorage *KeyMaterialStorage
+	keyType    string
+	nodeName   string
+
+	// newRequest builds the CSR to submit, the name and labels get filled in afterwards
+	newRequest func(ctx context.Context, key crypto.Signer) (*certv1.CertificateSigningRequest, error)
+	// onStuck is called once if nobody approves the CSR for a while
+	onStuck func(csrName string, pendingFor time.Duration)
+}
+
+func (ka *KubeApi) PerformCertificateFlow(ctx context.Context) error {
+	csrApi, err := newCsrClient(ka.kubernetes)
 	if err != nil {
 		return err
 	}
 
-	csr, err := csrApi.Get(ctx, csrName, metav1.GetOptions{})
+	flow := &csrFlow{
+		csrApi:     csrApi,
+		keyStorage: ka.keyStorage,
+		keyType:    ka.keyType,
+		nodeName:   ka.nodeName,
+
+		newRequest: func(ctx context.Context, key crypto.Signer) (*certv1.CertificateSigningRequest, error) {
+			// get my UID and addresses first..
+			me, err := ka.kubernetes.CoreV1().Nodes().Get(ctx, ka.nodeName, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			ka.certLock.Lock()
+			ka.nodeUID = me.UID
+			ka.certLock.Unlock()
+
+			dnsNames, ipAddresses := servingSubjectAltNames(me, ka.nodeName, ka.nodeIP)
+			signerName := certv1.KubeletServingSignerName
+			request, err := GenerateCertRequest(key, pkix.Name{
+				Organization: []string{"system:nodes"},
+				CommonName:   "system:node:" + ka.nodeName,
+			}, dnsNames, ipAddresses)
+			if err != nil {
+				return nil, err
+			}
+
+			return &certv1.CertificateSigningRequest{
+				ObjectMeta: metav1.ObjectMeta{
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							APIVersion: "v1",
+							Kind:       "Node",
+							Name:       ka.nodeName,
+							UID:        me.UID,
+						},
+					},
+				},
+				Spec: certv1.CertificateSigningRequestSpec{
+					Request:    request,
+					SignerName: &signerName,
+					Usages: []certv1.KeyUsage{
+						certv1.UsageDigitalSignature,
+						certv1.UsageKeyEncipherment,
+						certv1.UsageServerAuth,
+					},
+				},
+			}, nil
+		},
+
+		onStuck: func(csrName string, pendingFor time.Duration) {
+			ka.events.Eventf(ka.nodeRef(ctx), corev1.EventTypeWarning, "CertificateApprovalPending", "CSR %s has been waiting for approval for %v", csrName, pendingFor.Round(time.Minute))
+		},
+	}
+	return flow.Perform(ctx)
+}
+
+// Perform waits for a certificate and stores it next to the key
+func (cf *csrFlow) Perform(ctx context.Context) error {
+	key, err := cf.keyStorage.LoadOrGeneratePrivateKey(cf.keyType)
 	if err != nil {
-		if !strings.Contains(err.Error(), "not found") { // TODO
-			return err
-		}
+		return err
+	}
 
+	// pick up where we left off if we restarted while waiting for approval
+	csr, err := cf.findPendingCsr(ctx, key)
+	if err != nil {
+		return err
+	}
+	if csr == nil {
 		// no CSR yet, so let's make one
-		csrParams := createServerAuthCsrParams(ka.nodeName, ka.nodeIP.String())
-		request, err := GenerateCertRequest(ka.keyStorage, csrParams)
+		csr, err = cf.newRequest(ctx, key)
 		if err != nil {
 			return err
 		}
 
-		// get my UID first..
-		me, err := ka.kubernetes.CoreV1().Nodes().Get(ctx, ka.nodeName, metav1.GetOptions{})
-		if err != nil {
-			return err
+		// submit to API, with a fresh name each time so that renewals don't collide
+		csr.ObjectMeta.GenerateName = "kube-pet-node." + cf.nodeName + "-"
+		csr.ObjectMeta.Labels = map[string]string{
+			"kubernetes.io/role": "pet",
+			csrNodeLabel:         cf.nodeName,
 		}
-
-		// submit to API
-		csr, err = csrApi.Create(ctx, &certv1.CertificateSigningRequest{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: csrName,
-				Labels: map[string]string{
-					"kubernetes.io/role": "pet",
-				},
-				OwnerReferences: []metav1.OwnerReference{
-					{
-						APIVersion: "v1",
-						Kind:       "Node",
-						Name:       ka.nodeName,
-						UID:        me.UID,
-					},
-				},
-			},
-			Spec: certv1.CertificateSigningRequestSpec{
-				Request: request,
-				// SignerName: "kubernetes.io/kubelet-serving",
-				Usages: []certv1.KeyUsage{
-					certv1.UsageDigitalSignature,
-					certv1.UsageKeyEncipherment,
-					certv1.UsageServerAuth,
-				},
-			},
-		}, metav1.CreateOptions{})
+		csr, err = cf.csrApi.Create(ctx, csr, metav1.CreateOptions{})
 		if err != nil {
 			return err
 		}
 
-		log.Println("Submitted request for a server auth certificate")
+		log.Println("Submitted request for a", cf.keyStorage.materialId, "certificate")
 	}
+	csrName := csr.ObjectMeta.Name
 
 	log.Println("Please approve my CSR!")
 	log.Println("  $ kubectl certificate approve", csrName)
 
+	stuck := false
+	defer certificatePending.Set(0, cf.keyStorage.materialId)
 	for len(csr.Status.Certificate) == 0 {
+		// approved CSRs can still take a moment to be signed, but these are final
+		if condition := csrFinalCondition(csr); condition != nil {
+			return fmt.Errorf("CSR %s was %s: %s %s", csrName, condition.Type, condition.Reason, condition.Message)
+		}
 		log.Println("CSR pending...")
 
-		time.Sleep(time.Second * 5)
-		csr, err = csrApi.Get(ctx, csrName, metav1.GetOptions{})
-		if err != nil {
+		pendingFor := time.Since(csr.ObjectMeta.CreationTimestamp.Time)
+		certificatePending.Set(pendingFor.Seconds(), cf.keyStorage.materialId)
+		if pendingFor > csrPendingWarning && !stuck {
+			cf.onStuck(csrName, pendingFor)
+			stuck = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second * 5):
+		}
+		csr, err = cf.csrApi.Get(ctx, csrName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("CSR %s was deleted before being signed", csrName)
+		} else if err != nil {
 			return err
 		}
 	}
@@ -92,10 +168,49 @@ func (ka *KubeApi) PerformCertificateFlow(ctx context.Context) error {
 		log.Println("CSR", condition.Type, "-", condition.Reason, "-", condition.Message)
 	}
 
-	if err := ka.keyStorage.StoreFile(".crt", csr.Status.Certificate); err != nil {
+	if err := cf.keyStorage.StoreFile(".crt", csr.Status.Certificate); err != nil {
 		return err
 	}
-	log.Println("Write newly minted kubeapi certificate out to disk :D")
+	log.Println("Wrote newly minted", cf.keyStorage.materialId, "certificate out to disk :D")
+
+	return nil
+}
+
+// findPendingCsr returns our newest CSR that hasn't been answered yet, if any
+func (cf *csrFlow) findPendingCsr(ctx context.Context, key crypto.Signer) (*certv1.CertificateSigningRequest, error) {
+	list, err := cf.csrApi.List(ctx, metav1.ListOptions{
+		LabelSelector: csrNodeLabel + "=" + cf.nodeName,
+	})
+	if errors.IsForbidden(err) {
+		// nodes are allowed to make CSRs but not always to list them
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
 
+	var newest *certv1.CertificateSigningRequest
+	for idx := range list.Items {
+		csr := &list.Items[idx]
+		if len(csr.Status.Certificate) > 0 || csrFinalCondition(csr) != nil {
+			continue
+		}
+		// a request for some older key, or a different certificate, is no use to us
+		if !requestMatchesKey(csr.Spec.Request, key) {
+			continue
+		}
+		if newest == nil || newest.ObjectMeta.CreationTimestamp.Before(&csr.ObjectMeta.CreationTimestamp) {
+			newest = csr
+		}
+	}
+	return newest, nil
+}
+
+// csrFinalCondition returns the condition that means a CSR will never be signed, if there is one
+func csrFinalCondition(csr *certv1.CertificateSigningRequest) *certv1.CertificateSigningRequestCondition {
+	for idx, condition := range csr.Status.Conditions {
+		if condition.Type == certv1.CertificateDenied || condition.Type == csrConditionFailed {
+			return &csr.Status.Conditions[idx]
+		}
+	}
 	return nil
 }
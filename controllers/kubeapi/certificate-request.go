@@ -1,69 +1,127 @@
 package kubeapi
 
 import (
-	// "log"
-	"io"
-	"io/ioutil"
-	"os/exec"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"reflect"
 
-	ini "gopkg.in/ini.v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/keyutil"
 )
 
-func GenerateCertRequest(keyStorage *KeyMaterialStorage, paramsSource io.WriterTo) ([]byte, error) {
-	reqCmd := exec.Command("openssl", "req", "-new",
-		"-key", keyStorage.GetFilePath(".key"),
-		"-config", "/dev/stdin",
-		"-out", "/dev/stdout")
+// key types for new private keys
+const (
+	KeyTypeECDSA = "ecdsa" // P-256
+	KeyTypeRSA   = "rsa"   // 2048 bits
+)
 
-	stdin, err := reqCmd.StdinPipe()
-	if err != nil {
-		return []byte{}, err
+// GeneratePrivateKey makes a new key and returns it along with its PEM encoding
+func GeneratePrivateKey(keyType string) (crypto.Signer, []byte, error) {
+	var key crypto.Signer
+	var err error
+	switch keyType {
+	case KeyTypeECDSA, "":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeRSA:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, nil, fmt.Errorf("unknown key type %q, expected %q or %q", keyType, KeyTypeECDSA, KeyTypeRSA)
 	}
-	stdout, err := reqCmd.StdoutPipe()
 	if err != nil {
-		return []byte{}, err
+		return nil, nil, err
 	}
 
-	// start the process
-	if err := reqCmd.Start(); err != nil {
-		return []byte{}, err
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return nil, nil, err
 	}
+	return key, keyPEM, nil
+}
 
-	if _, err := paramsSource.WriteTo(stdin); err != nil {
-		return []byte{}, err
+// ParsePrivateKey reads back a PEM key, including the RSA keys that openssl used to make for us
+func ParsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	key, err := keyutil.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, err
 	}
-	stdin.Close() // so that openssl will process and exit
-
-	request, err := ioutil.ReadAll(stdout)
-	// prefer throwing Wait() error, even if ReadAll() already gave an error
-	if err := reqCmd.Wait(); err != nil {
-		return request, err
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key of type %T can't sign", key)
 	}
-	return request, err
+	return signer, nil
 }
 
-func createServerAuthCsrParams(nodeName, nodeIP string) *ini.File {
-	file := ini.Empty()
+// GenerateCertRequest returns a PEM CSR for the given subject and SANs, signed by the key
+func GenerateCertRequest(key crypto.Signer, subject pkix.Name, dnsNames []string, ipAddresses []net.IP) ([]byte, error) {
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     subject,
+		DNSNames:    dnsNames,
+		IPAddresses: ipAddresses,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
 
-	req, _ := file.NewSection("req")
-	req.NewKey("days", "30")
-	req.NewKey("prompt", "no")
-	req.NewKey("req_extensions", "v3_req") // section reference
-	req.NewKey("distinguished_name", "dn") // section reference
+// requestMatchesKey checks whether a PEM CSR was made with our key
+func requestMatchesKey(requestPEM []byte, key crypto.Signer) bool {
+	block, _ := pem.Decode(requestPEM)
+	if block == nil {
+		return false
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(request.PublicKey, key.Public())
+}
 
-	dn, _ := file.NewSection("dn")
-	dn.NewKey("O", "system:nodes")
-	dn.NewKey("CN", "system:node:"+nodeName)
+// servingSubjectAltNames covers every address that the node reports, plus our node name and IP
+func servingSubjectAltNames(node *corev1.Node, nodeName string, nodeIP net.IP) ([]string, []net.IP) {
+	dnsNames := []string{nodeName}
+	ipAddresses := []net.IP{}
+	if nodeIP != nil && !nodeIP.IsUnspecified() {
+		ipAddresses = append(ipAddresses, nodeIP)
+	}
 
-	v3_req, _ := file.NewSection("v3_req")
-	v3_req.NewKey("subjectAltName", "@alt_names") // section reference
-	v3_req.NewKey("basicConstraints", "CA:FALSE")
-	v3_req.NewKey("extendedKeyUsage", "serverAuth")
-	v3_req.NewKey("keyUsage", "nonRepudiation, digitalSignature, keyEncipherment")
+	if node != nil {
+		for _, addr := range node.Status.Addresses {
+			switch addr.Type {
+			case corev1.NodeInternalIP, corev1.NodeExternalIP:
+				if ip := net.ParseIP(addr.Address); ip != nil {
+					ipAddresses = appendIP(ipAddresses, ip)
+				}
+			case corev1.NodeHostName, corev1.NodeInternalDNS, corev1.NodeExternalDNS:
+				dnsNames = appendName(dnsNames, addr.Address)
+			}
+		}
+	}
+	return dnsNames, ipAddresses
+}
 
-	alt_names, _ := file.NewSection("alt_names")
-	alt_names.NewKey("DNS.1", nodeName)
-	alt_names.NewKey("IP.1", nodeIP)
+func appendIP(list []net.IP, ip net.IP) []net.IP {
+	for _, existing := range list {
+		if existing.Equal(ip) {
+			return list
+		}
+	}
+	return append(list, ip)
+}
 
-	return file
+func appendName(list []string, name string) []string {
+	for _, existing := range list {
+		if existing == name {
+			return list
+		}
+	}
+	return append(list, name)
 }
@@ -0,0 +1,126 @@
+package kubeapi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// how long to wait before trying again when a rotation fails
+const rotationRetryInterval = 5 * time.Minute
+
+// loadServingCert reads the key pair from disk and makes it the one that new TLS connections get
+func (ka *KubeApi) loadServingCert() error {
+	cert, err := tls.LoadX509KeyPair(
+		ka.keyStorage.GetFilePath(".crt"),
+		ka.keyStorage.GetFilePath(".key"))
+	if err != nil {
+		return err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return err
+	}
+
+	ka.certLock.Lock()
+	ka.servingCert = &cert
+	ka.certLock.Unlock()
+
+	certificateExpiry.Set(float64(cert.Leaf.NotAfter.Unix()), ka.keyStorage.materialId)
+	log.Println("kubeapi: Serving with certificate valid until", cert.Leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// getServingCert is our tls.Config.GetCertificate, so listeners pick up a rotated certificate without restarting
+func (ka *KubeApi) getServingCert(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	ka.certLock.RLock()
+	defer ka.certLock.RUnlock()
+	if ka.servingCert == nil {
+		return nil, fmt.Errorf("no serving certificate loaded yet")
+	}
+	return ka.servingCert, nil
+}
+
+// rotationDeadline picks a random point 70-90% of the way through the certificate's lifetime,
+// the same window that kubelet uses
+func rotationDeadline(cert *x509.Certificate) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	jitter := rand.New(rand.NewSource(time.Now().UnixNano())).Float64()
+	jittered := time.Duration(float64(lifetime) * (0.7 + 0.2*jitter))
+	return cert.NotBefore.Add(jittered)
+}
+
+// rotateServingCert requests a new certificate each time the current one gets old
+func (ka *KubeApi) rotateServingCert(ctx context.Context) {
+	for {
+		ka.certLock.RLock()
+		leaf := ka.servingCert.Leaf
+		ka.certLock.RUnlock()
+
+		deadline := rotationDeadline(leaf)
+		log.Println("kubeapi: Will rotate our serving certificate at", deadline.Format(time.RFC3339))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(deadline)):
+		}
+
+		for {
+			err := ka.PerformCertificateFlow(ctx)
+			if err == nil {
+				err = ka.loadServingCert()
+			}
+			if err == nil {
+				certificateRotations.Inc("success")
+				ka.events.Eventf(ka.nodeRef(ctx), corev1.EventTypeNormal, "CertificateRotated", "Rotated the kubelet serving certificate")
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			certificateRotations.Inc("failed")
+			log.Println("kubeapi WARN: Failed to rotate our serving certificate, will retry:", err)
+			if time.Now().After(leaf.NotAfter) {
+				ka.events.Eventf(ka.nodeRef(ctx), corev1.EventTypeWarning, "CertificateExpired", "The kubelet serving certificate expired at %s and could not be rotated: %v", leaf.NotAfter.Format(time.RFC3339), err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(rotationRetryInterval):
+			}
+		}
+	}
+}
+
+// nodeRef is how kubelet points events at its own node,
+// looking up our node's UID if the certificate flow hasn't already
+func (ka *KubeApi) nodeRef(ctx context.Context) *corev1.ObjectReference {
+	ka.certLock.RLock()
+	nodeUID := ka.nodeUID
+	ka.certLock.RUnlock()
+
+	if nodeUID == "" {
+		if me, err := ka.kubernetes.CoreV1().Nodes().Get(ctx, ka.nodeName, metav1.GetOptions{}); err != nil {
+			log.Println("kubeapi WARN: Failed to get our node for an event:", err)
+		} else {
+			nodeUID = me.UID
+			ka.certLock.Lock()
+			ka.nodeUID = nodeUID
+			ka.certLock.Unlock()
+		}
+	}
+
+	return &corev1.ObjectReference{
+		Kind: "Node",
+		Name: ka.nodeName,
+		UID:  nodeUID,
+	}
+}
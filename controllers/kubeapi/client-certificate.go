@@ -0,0 +1,285 @@
+package kubeapi
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	certv1 "k8s.io/api/certificates/v1beta1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/util/connrotation"
+)
+
+// ClientCertManager keeps the client certificate that we talk to the API server with,
+// like kubelet does when given a bootstrap kubeconfig
+type ClientCertManager struct {
+	keyStorage *KeyMaterialStorage
+	nodeName   string
+	keyType    string
+
+	// lets us drop connections that were made with an old certificate
+	dialer *connrotation.Dialer
+
+	certLock   sync.RWMutex
+	clientCert *tls.Certificate
+}
+
+func NewClientCertManager(nodeName, keyType string) (*ClientCertManager, error) {
+	keyStorage, err := NewKeyMaterialStorage("kubelet-client")
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClientCertManager{
+		keyStorage: keyStorage,
+		nodeName:   nodeName,
+		keyType:    keyType,
+		dialer:     connrotation.NewDialer((&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext),
+	}, nil
+}
+
+// Bootstrap uses the bootstrap kubeconfig to get our first certificate,
+// unless kubeconfigPath already has a usable one.
+// Returns false if kubeconfigPath is someone else's, which we leave alone.
+func (cm *ClientCertManager) Bootstrap(ctx context.Context, bootstrapPath, kubeconfigPath string) (bool, error) {
+	if existing, err := clientcmd.LoadFromFile(kubeconfigPath); err == nil {
+		if !cm.ownsKubeconfig(existing) {
+			log.Println("Not bootstrapping because", kubeconfigPath, "doesn't use our client certificate")
+			return false, nil
+		}
+		if err := cm.loadClientCert(); err == nil && time.Now().Before(cm.clientCert.Leaf.NotAfter) {
+			return true, nil
+		} else if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		log.Println("Our client certificate is missing or expired, bootstrapping a new one")
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	bootstrapConfig, err := clientcmd.LoadFromFile(bootstrapPath)
+	if err != nil {
+		return false, err
+	}
+	restConfig, err := clientcmd.NewDefaultClientConfig(*bootstrapConfig, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return false, err
+	}
+	bootstrapClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return false, err
+	}
+
+	log.Println("Requesting a client certificate for system:node:"+cm.nodeName, "using", bootstrapPath)
+	flow, err := cm.csrFlow(bootstrapClient)
+	if err != nil {
+		return false, err
+	}
+	if err := flow.Perform(ctx); err != nil {
+		return false, err
+	}
+	if err := cm.loadClientCert(); err != nil {
+		return false, err
+	}
+
+	return true, cm.writeKubeconfig(bootstrapConfig, kubeconfigPath)
+}
+
+func (cm *ClientCertManager) ownsKubeconfig(kubeconfig *clientcmdapi.Config) bool {
+	kubeCtx, ok := kubeconfig.Contexts[kubeconfig.CurrentContext]
+	if !ok {
+		return false
+	}
+	authInfo, ok := kubeconfig.AuthInfos[kubeCtx.AuthInfo]
+	return ok && authInfo.ClientCertificate == cm.keyStorage.GetFilePath(".crt")
+}
+
+// writeKubeconfig points at our key files, so that rotating them doesn't touch the kubeconfig
+func (cm *ClientCertManager) writeKubeconfig(bootstrapConfig *clientcmdapi.Config, kubeconfigPath string) error {
+	bootstrapCtx, ok := bootstrapConfig.Contexts[bootstrapConfig.CurrentContext]
+	if !ok {
+		return fmt.Errorf("bootstrap kubeconfig has no current context")
+	}
+	cluster, ok := bootstrapConfig.Clusters[bootstrapCtx.Cluster]
+	if !ok {
+		return fmt.Errorf("bootstrap kubeconfig has no cluster %q", bootstrapCtx.Cluster)
+	}
+
+	userName := "system:node:" + cm.nodeName
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters["default-cluster"] = cluster
+	kubeconfig.AuthInfos[userName] = &clientcmdapi.AuthInfo{
+		ClientCertificate: cm.keyStorage.GetFilePath(".crt"),
+		ClientKey:         cm.keyStorage.GetFilePath(".key"),
+	}
+	kubeconfig.Contexts["default-context"] = &clientcmdapi.Context{
+		Cluster:  "default-cluster",
+		AuthInfo: userName,
+	}
+	kubeconfig.CurrentContext = "default-context"
+
+	data, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return err
+	}
+	log.Println("Writing our node kubeconfig to", kubeconfigPath)
+	return writeFileAtomically(kubeconfigPath, data)
+}
+
+// WrapConfig makes a client config present whichever certificate is current,
+// so that clientsets made from it keep working across rotations
+func (cm *ClientCertManager) WrapConfig(config *rest.Config) error {
+	if err := cm.loadClientCert(); err != nil {
+		return err
+	}
+
+	tlsConfig, err := rest.TLSConfigFor(config)
+	if err != nil {
+		return err
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig.Certificates = nil
+	tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		cm.certLock.RLock()
+		defer cm.certLock.RUnlock()
+		return cm.clientCert, nil
+	}
+
+	config.Transport = utilnet.SetTransportDefaults(&http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConnsPerHost: 25,
+		DialContext:         cm.dialer.DialContext,
+	})
+	// the transport has all of this now, and client-go refuses to have both
+	config.TLSClientConfig = rest.TLSClientConfig{}
+	return nil
+}
+
+// Run rotates our client certificate, using the certificate itself to ask for the next one
+func (cm *ClientCertManager) Run(ctx context.Context, kubernetes *kubernetes.Clientset) {
+	for {
+		cm.certLock.RLock()
+		leaf := cm.clientCert.Leaf
+		cm.certLock.RUnlock()
+
+		deadline := rotationDeadline(leaf)
+		log.Println("Will rotate our client certificate at", deadline.Format(time.RFC3339))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(deadline)):
+		}
+
+		for {
+			flow, err := cm.csrFlow(kubernetes)
+			if err == nil {
+				err = flow.Perform(ctx)
+			}
+			if err == nil {
+				err = cm.loadClientCert()
+			}
+			if err == nil {
+				certificateRotations.Inc("success")
+				// reconnect so the API server sees the new certificate
+				cm.dialer.CloseAll()
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			certificateRotations.Inc("failed")
+			log.Println("WARN: Failed to rotate our client certificate, will retry:", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(rotationRetryInterval):
+			}
+		}
+	}
+}
+
+func (cm *ClientCertManager) csrFlow(kubernetes *kubernetes.Clientset) (*csrFlow, error) {
+	csrApi, err := newCsrClient(kubernetes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csrFlow{
+		csrApi:     csrApi,
+		keyStorage: cm.keyStorage,
+		keyType:    cm.keyType,
+		nodeName:   cm.nodeName,
+
+		newRequest: func(ctx context.Context, key crypto.Signer) (*certv1.CertificateSigningRequest, error) {
+			request, err := GenerateCertRequest(key, pkix.Name{
+				Organization: []string{"system:nodes"},
+				CommonName:   "system:node:" + cm.nodeName,
+			}, nil, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			// this signer gets auto-approved for nodes, the same as kubelet's requests
+			signerName := certv1.KubeAPIServerClientKubeletSignerName
+			return &certv1.CertificateSigningRequest{
+				Spec: certv1.CertificateSigningRequestSpec{
+					Request:    request,
+					SignerName: &signerName,
+					Usages: []certv1.KeyUsage{
+						certv1.UsageDigitalSignature,
+						certv1.UsageKeyEncipherment,
+						certv1.UsageClientAuth,
+					},
+				},
+			}, nil
+		},
+
+		onStuck: func(csrName string, pendingFor time.Duration) {
+			log.Println("WARN: Our client CSR", csrName, "has been waiting for approval for", pendingFor.Round(time.Minute))
+		},
+	}, nil
+}
+
+func (cm *ClientCertManager) loadClientCert() error {
+	certPEM, err := ioutil.ReadFile(cm.keyStorage.GetFilePath(".crt"))
+	if err != nil {
+		return err
+	}
+	keyPEM, err := ioutil.ReadFile(cm.keyStorage.GetFilePath(".key"))
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return err
+	}
+
+	cm.certLock.Lock()
+	cm.clientCert = &cert
+	cm.certLock.Unlock()
+
+	certificateExpiry.Set(float64(cert.Leaf.NotAfter.Unix()), cm.keyStorage.materialId)
+	return nil
+}
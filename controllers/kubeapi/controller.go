@@ -2,42 +2,64 @@ package kubeapi
 
 import (
 	"context"
+	"crypto/tls"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"sync"
+	"time"
 
 	// metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	// certv1 "k8s.io/api/certificates/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 
 	vkapi "github.com/virtual-kubelet/virtual-kubelet/node/api"
 
 	"github.com/danopia/kube-pet-node/controllers/pods"
+	"github.com/danopia/kube-pet-node/pkg/metrics"
 )
 
+// how long in-flight requests get when we're shutting down
+const serverShutdownTimeout = 5 * time.Second
+
 type KubeApi struct {
 	keyStorage *KeyMaterialStorage
 	kubernetes *kubernetes.Clientset
+	events     record.EventRecorder
 	podManager *pods.PodManager
 	nodeName   string
 	nodeIP     net.IP
+	hostExec   bool
+	rootless   bool
+	keyType    string
+	authOpts   AuthOptions
+
+	authnCache authCache
+	authzCache authCache
+
+	stats    *StatsCollector
+	health   healthChecks
+	debugMux *http.ServeMux
 
-	prevStats    map[string]prevStat
-	prevNodeStat *prevStat
+	certLock    sync.RWMutex
+	servingCert *tls.Certificate
+	nodeUID     types.UID // learned while requesting certificates, also under certLock
 
 	httpSrv  *http.Server
 	httpLnr  net.Listener
 	httpsSrv *http.Server
 	httpsLnr net.Listener
-}
+	// only on localhost
+	healthSrv *http.Server
+	healthLnr net.Listener
 
-type prevStat struct {
-	cpu  uint64
-	time uint64
+	done chan struct{}
 }
 
-func NewKubeApi(kubernetes *kubernetes.Clientset, podManager *pods.PodManager, nodeName string, nodeIP net.IP) (*KubeApi, error) {
+func NewKubeApi(kubernetes *kubernetes.Clientset, events record.EventRecorder, podManager *pods.PodManager, nodeName string, nodeIP net.IP, hostExec, rootless bool, keyType string, authOpts AuthOptions) (*KubeApi, error) {
 
 	if nodeIP == nil {
 		log.Println("WARN: kubeapi listening on all interfaces")
@@ -55,72 +77,160 @@ func NewKubeApi(kubernetes *kubernetes.Clientset, podManager *pods.PodManager, n
 		return nil, err
 	}
 
-	httpsSrv := &http.Server{Addr: nodeIP.String() + ":10250"}
+	httpsSrv := &http.Server{
+		Addr:      nodeIP.String() + ":10250",
+		TLSConfig: &tls.Config{},
+	}
+	if authOpts.ClientCAs != nil {
+		httpsSrv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		httpsSrv.TLSConfig.ClientCAs = authOpts.ClientCAs
+	} else {
+		log.Println("kubeapi WARN: No client CA available, so only bearer tokens will be accepted")
+	}
 	httpsLnr, err := net.Listen("tcp", httpsSrv.Addr)
 	if err != nil {
 		return nil, err
 	}
 
-	return &KubeApi{
+	healthSrv := &http.Server{Addr: healthzAddr}
+	healthLnr, err := net.Listen("tcp", healthSrv.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ka := &KubeApi{
 		keyStorage: keyStorage,
 		kubernetes: kubernetes,
+		events:     events,
 		podManager: podManager,
 		nodeName:   nodeName,
 		nodeIP:     nodeIP,
+		hostExec:   hostExec,
+		rootless:   rootless,
+		keyType:    keyType,
+		authOpts:   authOpts,
 
-		prevStats: map[string]prevStat{},
+		stats:    NewStatsCollector(podManager, nodeName),
+		debugMux: http.NewServeMux(),
 
 		httpSrv:  httpSrv,
 		httpLnr:  httpLnr,
 		httpsSrv: httpsSrv,
 		httpsLnr: httpsLnr,
-	}, nil
+
+		healthSrv: healthSrv,
+		healthLnr: healthLnr,
+
+		done: make(chan struct{}),
+	}
+	ka.AddHealthCheck("podman", ka.checkPodman)
+	ka.AddDebugHandler("pods", ka.ServeDebugPods)
+	// the serving certificate gets swapped out as it's rotated
+	httpsSrv.TLSConfig.GetCertificate = ka.getServingCert
+	return ka, nil
 }
 
 func (ka *KubeApi) Run(ctx context.Context) {
+	defer close(ka.done)
 	defer ka.httpsLnr.Close()
 
+	go ka.stats.Run(ctx)
+
 	secureMux := http.NewServeMux()
 	vkapi.AttachPodRoutes(vkapi.PodHandlerConfig{
-		RunInContainer:   ka.RunInContainer,
-		GetContainerLogs: ka.GetContainerLogs,
-		GetStatsSummary:  ka.GetStatsSummary,
+		RunInContainer:        ka.RunInContainer,
+		GetContainerLogs:      ka.GetContainerLogs,
+		GetPods:               ka.GetRunningPods,
+		GetPodsFromKubernetes: ka.GetPods,
+		GetStatsSummary:       ka.GetStatsSummary,
 	}, secureMux, true)
-	ka.httpsSrv.Handler = secureMux
+	// virtual-kubelet doesn't route attach, port-forward, or prometheus metrics,
+	// and its stats summary can't carry process counts
+	secureMux.HandleFunc("/stats/summary", ka.ServeStatsSummary)
+	secureMux.HandleFunc("/attach/", ka.ServeAttach)
+	secureMux.HandleFunc("/portForward/", ka.ServePortForward)
+	secureMux.HandleFunc("/metrics/resource", ka.ServeResourceMetrics)
+	secureMux.HandleFunc("/metrics/cadvisor", ka.ServeCadvisorMetrics)
+	secureMux.Handle("/metrics", metrics.Default)
+	secureMux.HandleFunc("/healthz", ka.ServeHealthz)
+	secureMux.HandleFunc("/healthz/", ka.ServeHealthz)
+	secureMux.Handle("/debug/", ka.debugMux)
+	ka.httpsSrv.Handler = ka.authFilter(secureMux)
 
 	insecureMux := http.NewServeMux()
 	vkapi.AttachPodMetricsRoutes(vkapi.PodMetricsConfig{
 		GetStatsSummary: ka.GetStatsSummary,
 	}, insecureMux)
+	insecureMux.HandleFunc("/stats/summary", ka.ServeStatsSummary)
+	insecureMux.HandleFunc("/metrics/resource", ka.ServeResourceMetrics)
+	insecureMux.HandleFunc("/metrics/cadvisor", ka.ServeCadvisorMetrics)
+	insecureMux.Handle("/metrics", metrics.Default)
+	insecureMux.HandleFunc("/healthz", ka.ServeHealthz)
+	insecureMux.HandleFunc("/healthz/", ka.ServeHealthz)
 	ka.httpSrv.Handler = insecureMux
 
-	// TODO: close servers when ctx cancels
+	healthMux := http.NewServeMux()
+	healthMux.HandleFunc("/healthz", ka.ServeHealthz)
+	healthMux.HandleFunc("/healthz/", ka.ServeHealthz)
+	ka.healthSrv.Handler = healthMux
 
 	// get HTTP going immediately / in the background
-	go func() {
-		defer ka.httpLnr.Close()
-		log.Fatalln(ka.httpSrv.Serve(ka.httpLnr))
-	}()
-
-	// try HTTPS once, half-expecting the key files to be missing
-	err := ka.httpsSrv.ServeTLS(
-		ka.httpsLnr,
-		ka.keyStorage.GetFilePath(".crt"),
-		ka.keyStorage.GetFilePath(".key"))
-
-	// from here down is only reached if the first TLS listen attempt failed
-	// fail hard if it wasn't expected
-	if !os.IsNotExist(err) {
-		log.Fatalln(err)
+	var servers sync.WaitGroup
+	serve := func(serveFunc func() error) {
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			if err := serveFunc(); err != http.ErrServerClosed {
+				log.Fatalln(err)
+			}
+		}()
 	}
-
-	if err := ka.PerformCertificateFlow(ctx); err != nil {
-		log.Fatalln(err)
+	serve(func() error { return ka.httpSrv.Serve(ka.httpLnr) })
+	serve(func() error { return ka.healthSrv.Serve(ka.healthLnr) })
+	defer servers.Wait()
+	defer ka.shutdown()
+
+	// load our certificate, half-expecting the key files to be missing
+	if err := ka.loadServingCert(); err != nil {
+		// fail hard if it wasn't expected
+		if !os.IsNotExist(err) {
+			log.Fatalln(err)
+		}
+
+		if err := ka.PerformCertificateFlow(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Fatalln(err)
+		}
+		if err := ka.loadServingCert(); err != nil {
+			log.Fatalln(err)
+		}
 	}
+	go ka.rotateServingCert(ctx)
+
+	// certificates come from GetCertificate, so no files here
+	serve(func() error { return ka.httpsSrv.ServeTLS(ka.httpsLnr, "", "") })
 
-	// try HTTPS once more, fail hard if not
-	log.Fatalln(ka.httpsSrv.ServeTLS(
-		ka.httpsLnr,
-		ka.keyStorage.GetFilePath(".crt"),
-		ka.keyStorage.GetFilePath(".key")))
+	<-ctx.Done()
+}
+
+// Done is closed once Run has stopped all of our servers
+func (ka *KubeApi) Done() <-chan struct{} {
+	return ka.done
+}
+
+// shutdown stops accepting requests and gives the current ones a moment to finish.
+// exec, attach, and port-forward streams are hijacked, so they don't hold this up.
+func (ka *KubeApi) shutdown() {
+	log.Println("kubeapi: Shutting down our servers")
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+
+	for _, srv := range []*http.Server{ka.httpsSrv, ka.httpSrv, ka.healthSrv} {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println("kubeapi WARN: Server", srv.Addr, "didn't shut down cleanly:", err)
+			srv.Close()
+		}
+	}
 }
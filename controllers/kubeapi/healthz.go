@@ -0,0 +1,85 @@
+package kubeapi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// same port as kubelet's healthz, which node-problem-detector and friends poll
+const healthzAddr = "127.0.0.1:10248"
+
+type HealthCheck func(ctx context.Context) error
+
+type healthChecks struct {
+	lock   sync.Mutex
+	checks map[string]HealthCheck
+}
+
+// AddHealthCheck makes /healthz fail while check does
+func (ka *KubeApi) AddHealthCheck(name string, check HealthCheck) {
+	ka.health.lock.Lock()
+	defer ka.health.lock.Unlock()
+	if ka.health.checks == nil {
+		ka.health.checks = make(map[string]HealthCheck)
+	}
+	ka.health.checks[name] = check
+}
+
+func (ka *KubeApi) checkPodman(ctx context.Context) error {
+	_, err := ka.podManager.GetPodman().Version(ctx)
+	return err
+}
+
+// ServeHealthz answers like kubelet: "ok", or a list of checks when something is wrong.
+// /healthz/<name> runs just the one check.
+func (ka *KubeApi) ServeHealthz(w http.ResponseWriter, req *http.Request) {
+	ka.health.lock.Lock()
+	names := make([]string, 0, len(ka.health.checks))
+	checks := make(map[string]HealthCheck, len(ka.health.checks))
+	for name, check := range ka.health.checks {
+		names = append(names, name)
+		checks[name] = check
+	}
+	ka.health.lock.Unlock()
+	sort.Strings(names)
+
+	if only := strings.TrimPrefix(req.URL.Path, "/healthz/"); only != req.URL.Path && only != "" {
+		if _, ok := checks[only]; !ok {
+			http.NotFound(w, req)
+			return
+		}
+		names = []string{only}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+	defer cancel()
+
+	var report strings.Builder
+	failed := false
+	for _, name := range names {
+		if err := checks[name](ctx); err != nil {
+			fmt.Fprintf(&report, "[-]%s failed: %v\n", name, err)
+			failed = true
+		} else {
+			fmt.Fprintf(&report, "[+]%s ok\n", name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if failed {
+		log.Print("kubeapi WARN: Health check failed:\n", report.String())
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, report.String(), "healthz check failed\n")
+	} else if _, verbose := req.URL.Query()["verbose"]; verbose {
+		fmt.Fprint(w, report.String(), "healthz check passed\n")
+	} else {
+		fmt.Fprint(w, "ok")
+	}
+}
@@ -0,0 +1,11 @@
+package kubeapi
+
+import (
+	"github.com/danopia/kube-pet-node/pkg/metrics"
+)
+
+var (
+	certificateExpiry    = metrics.NewGaugeVec("kube_pet_certificate_expiry_timestamp_seconds", "When each of our certificates stops being valid.", "certificate")
+	certificatePending   = metrics.NewGaugeVec("kube_pet_certificate_request_pending_seconds", "How long our current CSR has been waiting for approval, or 0 if there isn't one.", "certificate")
+	certificateRotations = metrics.NewCounterVec("kube_pet_certificate_rotations_total", "Attempts to rotate the kubelet serving certificate.", "result")
+)
@@ -0,0 +1,138 @@
+package kubeapi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	statsv1 "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+
+	"github.com/danopia/kube-pet-node/controllers/pods"
+)
+
+const (
+	// same as cadvisor's default housekeeping interval
+	statsInterval = 10 * time.Second
+	// a minute's worth of samples
+	statsHistory = 6
+)
+
+type cpuSample struct {
+	time uint64 // nanoseconds
+	cpu  uint64 // cumulative CPU nanoseconds
+}
+
+// cpuHistory is a small ring buffer of CPU counters for one container, or the node
+type cpuHistory struct {
+	samples [statsHistory]cpuSample
+	next    int
+	count   int
+}
+
+func (ch *cpuHistory) Add(sample cpuSample) {
+	ch.samples[ch.next] = sample
+	ch.next = (ch.next + 1) % statsHistory
+	if ch.count < statsHistory {
+		ch.count++
+	}
+}
+
+// Rate is the CPU usage in cores between the two newest samples.
+// It's not ok until there's been two samples.
+func (ch *cpuHistory) Rate() (float64, bool) {
+	if ch.count < 2 {
+		return 0, false
+	}
+	latest := ch.samples[(ch.next+statsHistory-1)%statsHistory]
+	previous := ch.samples[(ch.next+statsHistory-2)%statsHistory]
+	if latest.cpu < previous.cpu || latest.time <= previous.time {
+		// counters went backwards, so the thing was probably replaced
+		return 0, false
+	}
+	return calculateCPUFraction(latest.cpu, previous.cpu, latest.time, previous.time), true
+}
+
+// StatsCollector samples podman and /proc on an interval, so that every scraper sees
+// the same numbers and CPU rates don't depend on who asked last
+type StatsCollector struct {
+	podManager *pods.PodManager
+	nodeName   string
+
+	// only touched by the collection loop
+	conHistory  map[string]*cpuHistory
+	nodeHistory cpuHistory
+	fsUsage     *fsUsage
+
+	lock       sync.RWMutex
+	summary    *statsv1.Summary
+	containers map[containerKey]containerMeta
+}
+
+// containerKey finds a container's details which the summary doesn't have room for
+type containerKey struct {
+	podUID string
+	name   string
+}
+
+type containerMeta struct {
+	id    string
+	name  string // podman's name for it
+	image string
+	pids  uint64 // our stats types only have room for this in a user-defined metric
+}
+
+func NewStatsCollector(podManager *pods.PodManager, nodeName string) *StatsCollector {
+	return &StatsCollector{
+		podManager: podManager,
+		nodeName:   nodeName,
+		conHistory: make(map[string]*cpuHistory),
+	}
+}
+
+func (sc *StatsCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := sc.collect(ctx); err != nil {
+			log.Println("kubeapi WARN: Failed to collect stats:", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (sc *StatsCollector) collect(ctx context.Context) error {
+	summary, containers, err := sc.buildSummary(ctx)
+	if err != nil {
+		return err
+	}
+
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	sc.summary = summary
+	sc.containers = containers
+	return nil
+}
+
+// Summary is the most recently collected stats
+func (sc *StatsCollector) Summary() (*statsv1.Summary, error) {
+	summary, _, err := sc.Snapshot()
+	return summary, err
+}
+
+// Snapshot is the most recently collected stats, plus details about each container
+func (sc *StatsCollector) Snapshot() (*statsv1.Summary, map[containerKey]containerMeta, error) {
+	sc.lock.RLock()
+	defer sc.lock.RUnlock()
+	if sc.summary == nil {
+		return nil, nil, fmt.Errorf("stats haven't been collected yet")
+	}
+	return sc.summary, sc.containers, nil
+}
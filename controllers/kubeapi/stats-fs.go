@@ -0,0 +1,256 @@
+package kubeapi
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	statsv1 "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+
+	linuxproc "github.com/c9s/goprocinfo/linux"
+)
+
+// disk usage means walking directories, so it's refreshed less often than everything else
+const fsStatsInterval = time.Minute
+
+// fsUsage is the slow half of the stats, in bytes
+type fsUsage struct {
+	time        time.Time
+	rootfs      map[string]uint64 // by container ID
+	logs        map[string]uint64 // by container ID
+	volumes     map[string]uint64 // by podman volume name
+	images      uint64
+	graphRoot   string
+	graphDriver string
+
+	// not disk usage, but inspecting is when we find out
+	imageNames map[string]string // by container ID
+}
+
+// refreshFsUsage re-measures disk usage when it's stale, or when there's containers we haven't measured yet
+func (sc *StatsCollector) refreshFsUsage(ctx context.Context, containerIDs []string) {
+	if sc.fsUsage != nil && time.Since(sc.fsUsage.time) < fsStatsInterval {
+		missing := false
+		for _, conID := range containerIDs {
+			if _, ok := sc.fsUsage.rootfs[conID]; !ok {
+				missing = true
+				break
+			}
+		}
+		if !missing {
+			return
+		}
+	}
+	podmanClient := sc.podManager.GetPodman()
+
+	usage := &fsUsage{
+		time:    time.Now(),
+		rootfs:  make(map[string]uint64, len(containerIDs)),
+		logs:    make(map[string]uint64, len(containerIDs)),
+		volumes: make(map[string]uint64),
+
+		imageNames: make(map[string]string, len(containerIDs)),
+	}
+	if sc.fsUsage != nil {
+		usage.graphRoot = sc.fsUsage.graphRoot
+		usage.graphDriver = sc.fsUsage.graphDriver
+	}
+
+	if usage.graphRoot == "" {
+		if info, err := podmanClient.Info(ctx); err != nil {
+			log.Println("kubeapi WARN: Failed to get podman's storage info:", err)
+		} else if info.Store != nil {
+			usage.graphRoot = info.Store.GraphRoot
+			usage.graphDriver = info.Store.GraphDriverName
+		}
+	}
+
+	for _, conID := range containerIDs {
+		conInsp, err := podmanClient.ContainerInspect(ctx, conID, true)
+		if err != nil {
+			log.Println("kubeapi WARN: Failed to measure container", conID, ":", err)
+			continue
+		}
+		usage.imageNames[conID] = conInsp.ImageName
+		if conInsp.SizeRw != nil && *conInsp.SizeRw >= 0 {
+			usage.rootfs[conID] = uint64(*conInsp.SizeRw)
+		} else {
+			usage.rootfs[conID] = 0
+		}
+		// journald logs don't have a path, and rootful paths might not be readable by us
+		if conInsp.LogPath != "" {
+			if logInfo, err := os.Stat(conInsp.LogPath); err == nil {
+				usage.logs[conID] = uint64(logInfo.Size())
+			}
+		}
+	}
+
+	if dfReport, err := podmanClient.SystemDf(ctx); err != nil {
+		log.Println("kubeapi WARN: Failed to measure podman volumes:", err)
+	} else {
+		for _, volReport := range dfReport.Volumes {
+			if volReport.Size > 0 {
+				usage.volumes[volReport.VolumeName] = uint64(volReport.Size)
+			} else {
+				usage.volumes[volReport.VolumeName] = 0
+			}
+		}
+		// each image's Size includes its shared base layers, so adding those up would count them again and again
+		layersSize, err := imageLayersSize(usage.graphRoot, usage.graphDriver)
+		if err == nil {
+			usage.images = layersSize
+		} else {
+			// rootful storage usually isn't readable by us, so settle for a lower bound
+			var largestShared int64
+			for _, imgReport := range dfReport.Images {
+				if imgReport.UniqueSize > 0 {
+					usage.images += uint64(imgReport.UniqueSize)
+				}
+				if imgReport.SharedSize > largestShared {
+					largestShared = imgReport.SharedSize
+				}
+			}
+			usage.images += uint64(largestShared)
+		}
+	}
+
+	sc.fsUsage = usage
+}
+
+// imageLayersSize adds up every layer that some image uses, once each,
+// going by containers/storage's own records of images and layers
+func imageLayersSize(graphRoot, graphDriver string) (uint64, error) {
+	if graphRoot == "" || graphDriver == "" {
+		return 0, os.ErrNotExist
+	}
+
+	var images []struct {
+		TopLayer string `json:"layer"`
+	}
+	imagesJSON, err := ioutil.ReadFile(filepath.Join(graphRoot, graphDriver+"-images", "images.json"))
+	if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(imagesJSON, &images); err != nil {
+		return 0, err
+	}
+
+	var layers []struct {
+		ID       string `json:"id"`
+		Parent   string `json:"parent"`
+		DiffSize int64  `json:"diff-size"`
+	}
+	layersJSON, err := ioutil.ReadFile(filepath.Join(graphRoot, graphDriver+"-layers", "layers.json"))
+	if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(layersJSON, &layers); err != nil {
+		return 0, err
+	}
+	layerIdx := make(map[string]int, len(layers))
+	for idx, layer := range layers {
+		layerIdx[layer.ID] = idx
+	}
+
+	// walk down from each image's top layer, stopping wherever another image already went
+	var totalBytes uint64
+	counted := make(map[string]bool, len(layers))
+	for _, image := range images {
+		for layerID := image.TopLayer; layerID != "" && !counted[layerID]; {
+			counted[layerID] = true
+			idx, ok := layerIdx[layerID]
+			if !ok {
+				break
+			}
+			if layers[idx].DiffSize > 0 {
+				totalBytes += uint64(layers[idx].DiffSize)
+			}
+			layerID = layers[idx].Parent
+		}
+	}
+	return totalBytes, nil
+}
+
+// statfsStats describes the whole filesystem which holds the path
+func statfsStats(path string, nowStamp metav1.Time) (*statsv1.FsStats, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return nil, err
+	}
+
+	capacityBytes := stat.Blocks * uint64(stat.Bsize)
+	availableBytes := stat.Bavail * uint64(stat.Bsize)
+	usedBytes := (stat.Blocks - stat.Bfree) * uint64(stat.Bsize)
+	inodes := stat.Files
+	inodesFree := stat.Ffree
+	inodesUsed := inodes - inodesFree
+	return &statsv1.FsStats{
+		Time:           nowStamp,
+		AvailableBytes: &availableBytes,
+		CapacityBytes:  &capacityBytes,
+		UsedBytes:      &usedBytes,
+		Inodes:         &inodes,
+		InodesFree:     &inodesFree,
+		InodesUsed:     &inodesUsed,
+	}, nil
+}
+
+// usageOn is a usage number for something living on a filesystem, so that capacity comes along
+func usageOn(fsStats *statsv1.FsStats, usedBytes uint64, nowStamp metav1.Time) *statsv1.FsStats {
+	usage := &statsv1.FsStats{
+		Time:      nowStamp,
+		UsedBytes: &usedBytes,
+	}
+	if fsStats != nil {
+		usage.AvailableBytes = fsStats.AvailableBytes
+		usage.CapacityBytes = fsStats.CapacityBytes
+	}
+	return usage
+}
+
+// podVolumeStats picks out the volumes which VolumesController made for the pod
+func (sc *StatsCollector) podVolumeStats(podUID string, imageFs *statsv1.FsStats, nowStamp metav1.Time) ([]statsv1.VolumeStats, uint64) {
+	if sc.fsUsage == nil {
+		return nil, 0
+	}
+
+	var volStats []statsv1.VolumeStats
+	var totalBytes uint64
+	for volName, usedBytes := range sc.fsUsage.volumes {
+		if !strings.HasPrefix(volName, podUID+"_") {
+			continue
+		}
+		volStats = append(volStats, statsv1.VolumeStats{
+			Name:    volName[len(podUID)+1:],
+			FsStats: *usageOn(imageFs, usedBytes, nowStamp),
+		})
+		totalBytes += usedBytes
+	}
+	return volStats, totalBytes
+}
+
+func readRlimitStats(nowStamp metav1.Time) (*statsv1.RlimitStats, error) {
+	maxPID, err := linuxproc.ReadMaxPID("/proc/sys/kernel/pid_max")
+	if err != nil {
+		return nil, err
+	}
+	pids, err := linuxproc.ListPID("/proc", maxPID)
+	if err != nil {
+		return nil, err
+	}
+
+	maxPIDs := int64(maxPID)
+	numProcs := int64(len(pids))
+	return &statsv1.RlimitStats{
+		Time:                  nowStamp,
+		MaxPID:                &maxPIDs,
+		NumOfRunningProcesses: &numProcs,
+	}, nil
+}
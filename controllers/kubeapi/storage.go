@@ -1,11 +1,13 @@
 package kubeapi
 
 import (
-	// "encoding/json"
-	// "log"
+	"crypto"
+	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"sync"
+	// "encoding/json"
 	// "strings"
 	// corev1 "k8s.io/api/core/v1"
 )
@@ -37,18 +39,25 @@ func (pss *KeyMaterialStorage) GetFilePath(extension string) string {
 	return filepath.Join(pss.rootDir, pss.materialId+extension)
 }
 
-func (pss *KeyMaterialStorage) EnsurePrivateKeyExists(keygen func(outPath string) error) error {
+// LoadOrGeneratePrivateKey reads our private key, making a new one of keyType if there isn't one yet
+func (pss *KeyMaterialStorage) LoadOrGeneratePrivateKey(keyType string) (crypto.Signer, error) {
 	keyPath := pss.GetFilePath(".key")
 
 	pss.lock.Lock()
 	defer pss.lock.Unlock()
 
-	if _, err := os.Stat(keyPath); err == nil {
-		return nil
+	if keyPEM, err := ioutil.ReadFile(keyPath); err == nil {
+		return ParsePrivateKey(keyPEM)
+	} else if !os.IsNotExist(err) {
+		return nil, err
 	}
 
-	// responsible for putting the new key on disk
-	return keygen(keyPath)
+	log.Println("Generating new", keyType, "private key at", keyPath)
+	key, keyPEM, err := GeneratePrivateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+	return key, writeFileAtomically(keyPath, keyPEM)
 }
 
 func (pss *KeyMaterialStorage) StoreFile(extension string, data []byte) error {
@@ -57,17 +66,30 @@ func (pss *KeyMaterialStorage) StoreFile(extension string, data []byte) error {
 	pss.lock.Lock()
 	defer pss.lock.Unlock()
 
-	file, err := os.Create(filePath)
+	return writeFileAtomically(filePath, data)
+}
+
+// writeFileAtomically goes through a temporary file, so that readers never see half of a key or certificate
+func writeFileAtomically(filePath string, data []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath)+".")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer os.Remove(file.Name()) // fails harmlessly once renamed
 
 	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
 		return err
 	}
 
-	return nil
+	return os.Rename(file.Name(), filePath)
 }
 
 // func (pss *KeyMaterialStorage) ReadPod(coord PodCoord) (*corev1.Pod, error) {
@@ -0,0 +1,183 @@
+package loadbalancer
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	kubernetes "k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/bep/debounce"
+)
+
+// LoadBalancerAnnotation goes on a LoadBalancer service with the name of the pet node which should expose it,
+// e.g. `kube-pet-node/load-balancer: pet-laxbox`
+const LoadBalancerAnnotation = "kube-pet-node/load-balancer"
+
+// our ExternalIP can change without any service changing
+const resyncPeriod = 5 * time.Minute
+
+// LoadBalancerController is a bare-metal LoadBalancer implementation:
+// services annotated for us get our node's ExternalIPs as their ingress,
+// and then the firewall sends traffic for those addresses to the service's endpoints.
+type LoadBalancerController struct {
+	nodeName string
+	coreV1   corev1client.CoreV1Interface
+
+	serviceInformer corev1informers.ServiceInformer
+	debounce        func(func())
+
+	// the services we've given an ingress, so we can take it back later
+	syncLock sync.Mutex
+	exposed  map[string]struct{}
+}
+
+func NewLoadBalancerController(nodeName string, kubernetes *kubernetes.Clientset, serviceInformer corev1informers.ServiceInformer) *LoadBalancerController {
+	return &LoadBalancerController{
+		nodeName: nodeName,
+		coreV1:   kubernetes.CoreV1(),
+
+		serviceInformer: serviceInformer,
+		debounce:        debounce.New(time.Second),
+		exposed:         make(map[string]struct{}),
+	}
+}
+
+func (lbc *LoadBalancerController) Run(ctx context.Context) {
+	for !lbc.serviceInformer.Informer().HasSynced() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(2 * time.Second):
+		}
+	}
+
+	sync := func() { lbc.Sync(ctx) }
+	lbc.serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if lbc.isOurs(obj) {
+				lbc.debounce(sync)
+			}
+		},
+		UpdateFunc: func(before, after interface{}) {
+			// a service that stops being ours needs its ingress cleared
+			if lbc.isOurs(before) || lbc.isOurs(after) {
+				lbc.debounce(sync)
+			}
+		},
+	})
+
+	ticker := time.NewTicker(resyncPeriod)
+	defer ticker.Stop()
+	for {
+		lbc.debounce(sync)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (lbc *LoadBalancerController) isOurs(obj interface{}) bool {
+	svc, ok := obj.(*corev1.Service)
+	return ok && svc.Spec.Type == corev1.ServiceTypeLoadBalancer &&
+		svc.ObjectMeta.Annotations[LoadBalancerAnnotation] == lbc.nodeName
+}
+
+// Sync points each of our services at our current ExternalIPs,
+// and clears the ingress of services that aren't ours anymore
+func (lbc *LoadBalancerController) Sync(ctx context.Context) {
+	lbc.syncLock.Lock()
+	defer lbc.syncLock.Unlock()
+
+	services, err := lbc.serviceInformer.Lister().List(labels.Everything())
+	if err != nil {
+		log.Println("LoadBalancer WARN: Failed to list services:", err)
+		return
+	}
+	var ours, others []*corev1.Service
+	listed := make(map[string]struct{}, len(services))
+	for _, svc := range services {
+		listed[svc.ObjectMeta.Namespace+"/"+svc.ObjectMeta.Name] = struct{}{}
+		if lbc.isOurs(svc) {
+			ours = append(ours, svc)
+		} else if len(svc.Status.LoadBalancer.Ingress) > 0 {
+			others = append(others, svc)
+		}
+	}
+	for key := range lbc.exposed {
+		if _, ok := listed[key]; !ok {
+			delete(lbc.exposed, key)
+		}
+	}
+	if len(ours) == 0 && len(others) == 0 {
+		return
+	}
+
+	node, err := lbc.coreV1.Nodes().Get(ctx, lbc.nodeName, metav1.GetOptions{})
+	if err != nil {
+		log.Println("LoadBalancer WARN: Failed to get our node:", err)
+		return
+	}
+	var ingress []corev1.LoadBalancerIngress
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == corev1.NodeExternalIP {
+			ingress = append(ingress, corev1.LoadBalancerIngress{IP: addr.Address})
+		}
+	}
+
+	// we don't know who else sets ingresses, so only take back what we gave out.
+	// after a restart, the best sign of that is the ingress still being our addresses
+	for _, svc := range others {
+		key := svc.ObjectMeta.Namespace + "/" + svc.ObjectMeta.Name
+		_, exposed := lbc.exposed[key]
+		if !exposed && (len(ingress) == 0 || !equality.Semantic.DeepEqual(svc.Status.LoadBalancer.Ingress, ingress)) {
+			continue
+		}
+		if lbc.setIngress(ctx, svc, nil) {
+			log.Println("LoadBalancer: Unexposed", key, "because it's not ours anymore")
+		}
+	}
+
+	if len(ingress) == 0 && len(ours) > 0 {
+		log.Println("LoadBalancer WARN: We don't have an ExternalIP, so", len(ours), "services are waiting")
+	}
+	for _, svc := range ours {
+		key := svc.ObjectMeta.Namespace + "/" + svc.ObjectMeta.Name
+		if lbc.setIngress(ctx, svc, ingress) {
+			log.Println("LoadBalancer: Exposed", key, "on", ingress)
+		}
+	}
+}
+
+// setIngress updates a service's ingress if it needs it, returning whether anything changed
+func (lbc *LoadBalancerController) setIngress(ctx context.Context, svc *corev1.Service, ingress []corev1.LoadBalancerIngress) bool {
+	key := svc.ObjectMeta.Namespace + "/" + svc.ObjectMeta.Name
+	if len(ingress) > 0 {
+		lbc.exposed[key] = struct{}{}
+	}
+	if equality.Semantic.DeepEqual(svc.Status.LoadBalancer.Ingress, ingress) ||
+		len(svc.Status.LoadBalancer.Ingress) == 0 && len(ingress) == 0 {
+		return false
+	}
+
+	svc = svc.DeepCopy()
+	svc.Status.LoadBalancer.Ingress = ingress
+	if _, err := lbc.coreV1.Services(svc.ObjectMeta.Namespace).UpdateStatus(ctx, svc, metav1.UpdateOptions{}); err != nil {
+		log.Println("LoadBalancer WARN: Failed to update", key, "status:", err)
+		return false
+	}
+	if len(ingress) == 0 {
+		delete(lbc.exposed, key)
+	}
+	return true
+}
@@ -9,7 +9,6 @@ import (
 
 	"github.com/virtual-kubelet/virtual-kubelet/node"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 
@@ -18,7 +17,12 @@ import (
 	"github.com/danopia/kube-pet-node/pkg/podman"
 )
 
-func NewNodeIdentity(ctx context.Context, kubernetes *kubernetes.Clientset, nodeName string, petVersion string, conVersion *podman.DockerVersionReport, maxPods int, nodeIP net.IP, podNets []net.IPNet) (*node.NodeController, error) {
+// Also used as a taint key, so pods need a toleration to run on a rootless node.
+// Kept out of kubernetes.io/, where NodeRestriction won't let us label ourselves
+const RootlessLabel = "kube-pet-node/rootless"
+
+// existingNode is our Node from the API, or nil if we're being registered for the first time
+func NewNodeIdentity(ctx context.Context, kubernetes *kubernetes.Clientset, existingNode *corev1.Node, nodeName string, petVersion string, conVersion *podman.DockerVersionReport, maxPods int, nodeIP net.IP, podNets []net.IPNet, rootless bool) (*node.NodeController, error) {
 
 	podCIDRs := make([]string, len(podNets))
 	for idx, podNet := range podNets {
@@ -43,12 +47,18 @@ func NewNodeIdentity(ctx context.Context, kubernetes *kubernetes.Clientset, node
 		},
 	}
 
+	// rootless pods have no IP and no ClusterIPs, so make them opt in to that
+	if rootless {
+		pNode.Spec.Taints = append(pNode.Spec.Taints, corev1.Taint{
+			Key:    RootlessLabel,
+			Value:  "true",
+			Effect: "NoSchedule",
+		})
+	}
+
 	// If our node already exists, use it for inspiration
-	existingNode, err := kubernetes.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
-	if err == nil {
+	if existingNode != nil {
 		existingNode.ObjectMeta.DeepCopyInto(&pNode.ObjectMeta)
-	} else if !errors.IsNotFound(err) {
-		return nil, err
 	}
 
 	pNode.ObjectMeta.Labels["purpose"] = "pet"
@@ -59,6 +69,11 @@ func NewNodeIdentity(ctx context.Context, kubernetes *kubernetes.Clientset, node
 	pNode.ObjectMeta.Labels["kubernetes.io/hostname"] = strings.TrimPrefix(nodeName, "pet-")
 	pNode.ObjectMeta.Labels["kubernetes.io/arch"] = runtime.GOARCH
 	pNode.ObjectMeta.Labels["kubernetes.io/os"] = runtime.GOOS
+	if rootless {
+		pNode.ObjectMeta.Labels[RootlessLabel] = "true"
+	} else {
+		delete(pNode.ObjectMeta.Labels, RootlessLabel)
+	}
 
 	// i made this one up to deal with external-dns
 	pNode.ObjectMeta.Annotations["kubernetes.io/node.class"] = "kube-pet"
@@ -20,7 +20,9 @@ import (
 	"github.com/danopia/kube-pet-node/pkg/podman"
 )
 
-// TODO: IPv6 can't be enabled until virtual-kubelet fixes corruption
+// TODO: IPv6 can't be enabled until virtual-kubelet fixes corruption:
+// it patches status.addresses merged by type, so a second ExternalIP or InternalIP
+// gets folded into the first one. That's also why only our primary InternalIP is listed.
 
 // PetNodeProvider is a node provider that fills in
 // the status and health for our Kubernetes Node object.
@@ -0,0 +1,121 @@
+package nodeshutdown
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	logindService   = "org.freedesktop.login1"
+	logindObject    = dbus.ObjectPath("/org/freedesktop/login1")
+	logindInterface = "org.freedesktop.login1.Manager"
+)
+
+// NodeShutdown holds a "delay" inhibitor lock on systemd-logind, like kubelet's graceful node shutdown.
+// logind tells us when the machine is about to go down,
+// and then waits for us to release the lock (or for InhibitDelayMaxSec) before continuing.
+type NodeShutdown struct {
+	GracePeriod time.Duration
+
+	bus     *dbus.Conn
+	signals chan *dbus.Signal
+
+	lock *os.File
+
+	shuttingDown chan struct{}
+}
+
+func NewNodeShutdown(gracePeriod time.Duration) (*NodeShutdown, error) {
+	bus, err := dbus.SystemBus()
+	if err != nil {
+		return nil, err
+	}
+	logind := bus.Object(logindService, logindObject)
+
+	// logind won't wait longer than this no matter what we ask for
+	if maxDelay, err := logind.GetProperty(logindInterface + ".InhibitDelayMaxUSec"); err != nil {
+		log.Println("NodeShutdown WARN: Failed to check InhibitDelayMaxSec:", err)
+	} else if usec, ok := maxDelay.Value().(uint64); ok && time.Duration(usec)*time.Microsecond < gracePeriod {
+		log.Println("NodeShutdown WARN: logind's InhibitDelayMaxSec is only", time.Duration(usec)*time.Microsecond,
+			"so pods won't get all of the", gracePeriod, "grace period")
+	}
+
+	var fd dbus.UnixFD
+	err = logind.Call(logindInterface+".Inhibit", 0,
+		"shutdown", "kube-pet-node", "Stopping pods before shutdown", "delay").Store(&fd)
+	if err != nil {
+		return nil, fmt.Errorf("taking logind inhibitor lock: %v", err)
+	}
+
+	err = bus.BusObject().Call("org.freedesktop.DBus.AddMatch", 0,
+		"type='signal',interface='"+logindInterface+"',member='PrepareForShutdown'").Err
+	if err != nil {
+		os.NewFile(uintptr(fd), "inhibit").Close()
+		return nil, err
+	}
+	signals := make(chan *dbus.Signal, 1)
+	bus.Signal(signals)
+
+	log.Println("NodeShutdown: Holding a shutdown inhibitor lock for a", gracePeriod, "grace period")
+	return &NodeShutdown{
+		GracePeriod: gracePeriod,
+
+		bus:     bus,
+		signals: signals,
+		lock:    os.NewFile(uintptr(fd), "inhibit"),
+
+		shuttingDown: make(chan struct{}),
+	}, nil
+}
+
+// Run waits for logind to announce that the machine is shutting down
+func (ns *NodeShutdown) Run(ctx context.Context) {
+	defer ns.bus.RemoveSignal(ns.signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case signal, ok := <-ns.signals:
+			if !ok {
+				log.Println("NodeShutdown WARN: Lost our D-Bus connection, won't notice the machine shutting down")
+				return
+			}
+			if signal.Name != logindInterface+".PrepareForShutdown" || len(signal.Body) != 1 {
+				continue
+			}
+			if active, _ := signal.Body[0].(bool); active {
+				log.Println("NodeShutdown: The machine is shutting down")
+				close(ns.shuttingDown)
+				return
+			}
+		}
+	}
+}
+
+// ShuttingDown is closed once the machine starts shutting down
+func (ns *NodeShutdown) ShuttingDown() <-chan struct{} {
+	return ns.shuttingDown
+}
+
+// IsShuttingDown is whether pods should be stopped on the way out
+func (ns *NodeShutdown) IsShuttingDown() bool {
+	select {
+	case <-ns.shuttingDown:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release lets the shutdown continue, once our pods are stopped
+func (ns *NodeShutdown) Release() {
+	if ns.lock != nil {
+		ns.lock.Close()
+		ns.lock = nil
+	}
+}
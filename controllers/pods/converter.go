@@ -13,7 +13,8 @@ import (
 )
 
 // CreatePod takes a Kubernetes Pod and deploys it within the provider.
-func ConvertPodConfig(pod *corev1.Pod, clusterDns net.IP, cniNet string) *podman.PodSpecGenerator {
+// rootlessNet is blank for CNI networking, otherwise a rootless mode like slirp4netns
+func ConvertPodConfig(pod *corev1.Pod, clusterDns net.IP, cniNet string, rootlessNet string) *podman.PodSpecGenerator {
 	key := pod.ObjectMeta.Namespace + "_" + pod.ObjectMeta.Name
 
 	shareNs := []string{"ipc", "net", "uts"}
@@ -21,10 +22,12 @@ func ConvertPodConfig(pod *corev1.Pod, clusterDns net.IP, cniNet string) *podman
 		shareNs = append(shareNs, "pid")
 	}
 
-	// TODO, probably: support rootless w/o pod IPs
 	var netConfig podman.PodNetworkConfig
 	if pod.Spec.HostNetwork {
 		netConfig.NetNS.NSMode = "host"
+	} else if rootlessNet != "" {
+		// no pod IP, just outbound connectivity through the host
+		netConfig.NetNS.NSMode = rootlessNet
 	} else {
 		netConfig.NetNS.NSMode = "bridge"
 		netConfig.CNINetworks = []string{cniNet}
@@ -316,7 +319,7 @@ func ConvertContainerConfig(pod *corev1.Pod, conSpec *corev1.Container, podId st
 	// TODO: ImagePullPolicy
 	// SecurityContext
 	// Stdin
-	// TODO: StdinOnce
+	// StdinOnce (handled by kubeapi's attach)
 	// TTY
 }
 
@@ -329,7 +332,7 @@ const (
 )
 
 func GetContainerOOMScoreAdjust(pod *corev1.Pod, container *corev1.Container, memoryCapacity int64) int {
-	if *pod.Spec.Priority >= int32(2*1000000000) {
+	if pod.Spec.Priority != nil && *pod.Spec.Priority >= int32(2*1000000000) {
 		return guaranteedOOMScoreAdj
 	}
 
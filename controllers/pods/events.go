@@ -0,0 +1,146 @@
+package pods
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/danopia/kube-pet-node/pkg/podman"
+)
+
+// Event reasons, via https://github.com/kubernetes/kubernetes/blob/master/pkg/kubelet/events/event.go
+const (
+	// Container event reasons
+	CreatedContainer        = "Created"
+	StartedContainer        = "Started"
+	FailedToCreateContainer = "Failed"
+	FailedToStartContainer  = "Failed"
+	KillingContainer        = "Killing"
+	ExceededGracePeriod     = "ExceededGracePeriod"
+	ContainerUnhealthy      = "Unhealthy"
+	ContainerExited         = "Failed"
+	BackOffStartContainer   = "BackOff"
+
+	// Image event reasons
+	PullingImage      = "Pulling"
+	PulledImage       = "Pulled"
+	FailedToPullImage = "Failed"
+
+	// Pod event reasons
+	FailedCreatePodSandBox = "FailedCreatePodSandBox"
+	FailedKillPod          = "FailedKillPod"
+	FailedMountVolume      = "FailedMount"
+	FailedSystemdUnits     = "FailedSystemdUnits" // ours, not kubelet's
+)
+
+// containerRef points an event at one container, the same way kubelet does
+func containerRef(podRef *corev1.ObjectReference, containerName string) *corev1.ObjectReference {
+	ref := podRef.DeepCopy()
+	ref.FieldPath = "spec.containers{" + containerName + "}"
+	return ref
+}
+
+func podRefOf(pod *corev1.Pod) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion:      "v1",
+		Kind:            "Pod",
+		Namespace:       pod.ObjectMeta.Namespace,
+		Name:            pod.ObjectMeta.Name,
+		UID:             pod.ObjectMeta.UID,
+		ResourceVersion: pod.ObjectMeta.ResourceVersion,
+	}
+}
+
+// terminatingPods tracks the pods that we're stopping on purpose,
+// so that their containers dying doesn't look like a crash
+type terminatingPods struct {
+	lock sync.Mutex
+	keys map[string]struct{}
+}
+
+func (tp *terminatingPods) Add(key string) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	if tp.keys == nil {
+		tp.keys = make(map[string]struct{})
+	}
+	tp.keys[key] = struct{}{}
+}
+
+func (tp *terminatingPods) Remove(key string) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	delete(tp.keys, key)
+}
+
+func (tp *terminatingPods) Has(key string) bool {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	_, ok := tp.keys[key]
+	return ok
+}
+
+// handleContainerEvent turns interesting podman events into Kubernetes events
+func (d *PodmanProvider) handleContainerEvent(evt podman.Event) {
+	if evt.Type != "container" {
+		return
+	}
+
+	// our containers are named namespace_pod_container
+	nameParts := strings.Split(evt.Actor.Attributes["name"], "_")
+	if len(nameParts) != 3 {
+		return
+	}
+	podCoord := PodCoord{Namespace: nameParts[0], Name: nameParts[1]}
+	known, ok := d.manager.GetPod(podCoord.Key())
+	if !ok || known.Kube == nil || d.terminating.Has(podCoord.Key()) {
+		return
+	}
+	conRef := containerRef(podRefOf(known.Kube), nameParts[2])
+
+	switch evt.Action {
+	case "start":
+		d.restarts.Started(podCoord.ContainerKey(nameParts[2]))
+		// a new instance is running, so the one that exited before it becomes --previous
+		if err := d.manager.PromotePreviousLogs(known.Kube.ObjectMeta.UID, nameParts[2]); err != nil {
+			log.Println("Pods WARN: Failed to roll previous logs of", podCoord, nameParts[2], ":", err)
+		}
+	case "died":
+		// hang onto this instance's logs in case the container comes back (e.g. via systemd)
+		go func() {
+			if err := d.manager.SavePreviousLogs(context.TODO(), podCoord, known.Kube.ObjectMeta.UID, nameParts[2]); err != nil {
+				log.Println("Pods WARN: Failed to keep logs of", podCoord, nameParts[2], ":", err)
+			}
+		}()
+
+		exitCode, _ := strconv.Atoi(evt.Actor.Attributes["containerExitCode"])
+		terminated := &corev1.ContainerStateTerminated{
+			ExitCode:    int32(exitCode),
+			Reason:      "Completed",
+			FinishedAt:  metav1.NewTime(time.Unix(0, evt.TimeNano)),
+			ContainerID: "podman://" + evt.Actor.ID,
+		}
+		if evt.TimeNano == 0 {
+			terminated.FinishedAt = metav1.NewTime(time.Now())
+		}
+		if exitCode != 0 {
+			terminated.Reason = "Error"
+			d.events.Eventf(conRef, corev1.EventTypeWarning, ContainerExited, "Container %s exited with code %d", nameParts[2], exitCode)
+		}
+		if shouldRestart(known.Kube, exitCode) {
+			d.restartContainer(podCoord, known.Kube, nameParts[2], terminated)
+		} else {
+			d.containerExited(known.Kube, nameParts[2], terminated, 0)
+		}
+	case "health_status":
+		if evt.Actor.Attributes["health_status"] == "unhealthy" {
+			d.events.Eventf(conRef, corev1.EventTypeWarning, ContainerUnhealthy, "Container %s is unhealthy", nameParts[2])
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 
@@ -49,15 +50,29 @@ func processPullStream(events <-chan podman.ImagePullReport) (ids []string, err
 	return
 }
 
+// PullImage pulls with each pull secret until one works. Events go to podRef, which is usually a container
 func (d *PodmanProvider) PullImage(ctx context.Context, imageRef string, pullSecrets []*corev1.Secret, podRef *corev1.ObjectReference) error {
-	d.events.Eventf(podRef, corev1.EventTypeNormal, "Pulling", "Pulling image \"%s\"", imageRef)
+	d.events.Eventf(podRef, corev1.EventTypeNormal, PullingImage, "Pulling image \"%s\"", imageRef)
+
+	startTime := time.Now()
+	err := d.pullImage(ctx, imageRef, pullSecrets, podRef)
+	if err != nil {
+		imagePullDuration.Observe(time.Since(startTime).Seconds(), "failure")
+		d.events.Eventf(podRef, corev1.EventTypeWarning, FailedToPullImage, "Failed to pull image \"%s\": %v", imageRef, err)
+	} else {
+		imagePullDuration.Observe(time.Since(startTime).Seconds(), "success")
+	}
+	return err
+}
+
+func (d *PodmanProvider) pullImage(ctx context.Context, imageRef string, pullSecrets []*corev1.Secret, podRef *corev1.ObjectReference) error {
 
 	pullStream, err := d.podman.Pull(ctx, imageRef, nil)
 	if err == nil {
 		imgIds, err := processPullStream(pullStream)
 		if err == nil {
 			log.Println("Pulled images", imgIds, "for", podRef.Namespace, "/", podRef.Name)
-			d.events.Eventf(podRef, corev1.EventTypeNormal, "Pulled", "Successfully pulled public image \"%s\"", imageRef)
+			d.events.Eventf(podRef, corev1.EventTypeNormal, PulledImage, "Successfully pulled public image \"%s\"", imageRef)
 			return nil
 		} else if !strings.Contains(err.Error(), "unauthorized") {
 			return err
@@ -91,7 +106,7 @@ func (d *PodmanProvider) PullImage(ctx context.Context, imageRef string, pullSec
 			imgIds, err := processPullStream(pullStream)
 			if err == nil {
 				log.Println("Pulled PRIVATE images", imgIds, "for", podRef.Namespace, "/", podRef.Name)
-				d.events.Eventf(podRef, corev1.EventTypeNormal, "Pulled", "Successfully pulled private image \"%s\"", imageRef)
+				d.events.Eventf(podRef, corev1.EventTypeNormal, PulledImage, "Successfully pulled private image \"%s\"", imageRef)
 				return nil
 			} else if !strings.Contains(err.Error(), "unauthorized") {
 				return err
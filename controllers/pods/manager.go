@@ -6,9 +6,12 @@ import (
 	"io"
 	"log"
 	"strings"
+	"sync"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 
 	"github.com/danopia/kube-pet-node/pkg/podman"
 )
@@ -16,7 +19,16 @@ import (
 type PodManager struct {
 	podman      *podman.PodmanClient
 	specStorage *PodSpecStorage
-	KnownPods   map[string]RunningPod
+	unitStorage *PodUnitStorage
+	prevLogs    *PreviousLogStorage
+
+	// KnownPods is written to while pods are created and deleted,
+	// so everyone else reads it through GetPod or SnapshotPods
+	KnownPods map[string]RunningPod
+	podsLock  sync.RWMutex
+
+	eventLock    sync.Mutex
+	eventHandler func(podman.Event)
 
 	// cniNet      string
 	// clusterDns  net.IP
@@ -34,7 +46,7 @@ type RunningPod struct {
 }
 
 //cniNet string, clusterDns net.IP
-func NewPodManager(podmanClient *podman.PodmanClient, storage *PodSpecStorage) (*PodManager, error) {
+func NewPodManager(podmanClient *podman.PodmanClient, storage *PodSpecStorage, rootless bool) (*PodManager, error) {
 	// specStorage, err := NewPodSpecStorage()
 	// if err != nil {
 	// 	return nil, err
@@ -94,6 +106,15 @@ func NewPodManager(podmanClient *podman.PodmanClient, storage *PodSpecStorage) (
 	}
 	log.Println("Pods: There are", len(knownPods), "known pods")
 
+	// get systemd out of the way before touching anything else
+	unitStorage, err := NewPodUnitStorage(rootless)
+	if err != nil {
+		return nil, err
+	}
+	if err := reconcileUnits(unitStorage, podmanClient, knownPods); err != nil {
+		return nil, err
+	}
+
 	for coord, foundPod := range foundPodMap {
 		log.Println("Pods: Found dangling pod", coord, "that wasn't stored, deleting from system")
 		result, err := podmanClient.PodRm(context.TODO(), foundPod.Id, true)
@@ -105,36 +126,110 @@ func NewPodManager(podmanClient *podman.PodmanClient, storage *PodSpecStorage) (
 
 	// foundVols, err := podmanClient.VolumeList(context.TODO(), map[string][]string{"tag"})
 
+	prevLogs, err := NewPreviousLogStorage()
+	if err != nil {
+		return nil, err
+	}
+	if err := prevLogs.Prune(7 * 24 * time.Hour); err != nil {
+		log.Println("Pods WARN: Failed to prune previous logs:", err)
+	}
+
+	log.Println("Creating PodManager")
+	pm := &PodManager{
+		podman:      podmanClient,
+		specStorage: storage,
+		unitStorage: unitStorage,
+		prevLogs:    prevLogs,
+		KnownPods:   knownPods,
+
+		eventHandler: func(podman.Event) {},
+
+		// cniNet:      cniNet,
+		// clusterDns: clusterDns,
+	}
+
 	// starting in podman 2.0.3 / 2.0.4, the events response header isn't flushed until the first event happens
 	go func() error {
 		eventStream, err := podmanClient.StreamEvents(context.TODO())
 		if err != nil {
 			return err
 		}
-		// TODO: plumb these somewhere
 		for evt := range eventStream {
 			log.Printf("Pods: Event %v %v %v %+v", evt.Type, evt.Action, evt.Status, evt.Actor)
+			pm.eventLock.Lock()
+			handler := pm.eventHandler
+			pm.eventLock.Unlock()
+			handler(evt)
 		}
 		log.Println("Pods: No more podman events")
 		return nil
 	}()
 
-	log.Println("Creating PodManager")
-	return &PodManager{
-		podman:      podmanClient,
-		specStorage: storage,
-		KnownPods:   knownPods,
+	return pm, nil
+}
 
-		// cniNet:      cniNet,
-		// clusterDns: clusterDns,
-	}, nil
+// HandleEvents sends every podman event to the given function, from a single goroutine
+func (pm *PodManager) HandleEvents(handler func(podman.Event)) {
+	pm.eventLock.Lock()
+	defer pm.eventLock.Unlock()
+	pm.eventHandler = handler
+}
+
+// reconcileUnits removes systemd units for pods which we're not keeping,
+// and fills in units for kept pods which should have them but don't
+func reconcileUnits(unitStorage *PodUnitStorage, podmanClient *podman.PodmanClient, knownPods map[string]RunningPod) error {
+	unitPods, err := unitStorage.ListAllPods()
+	if err != nil {
+		return err
+	}
+	for _, podCoord := range unitPods {
+		if known, ok := knownPods[podCoord.Key()]; ok && WantsSystemdUnits(known.Kube) {
+			continue
+		}
+		log.Println("Pods: Removing systemd units for", podCoord)
+		if err := unitStorage.Remove(context.TODO(), podCoord); err != nil {
+			return err
+		}
+	}
+
+	for _, known := range knownPods {
+		if !WantsSystemdUnits(known.Kube) || unitStorage.HasUnits(known.Coord) {
+			continue
+		}
+		log.Println("Pods: Installing missing systemd units for", known.Coord)
+		if err := unitStorage.Install(context.TODO(), podmanClient, known.Coord); err != nil {
+			log.Println("Pods WARN: Failed to install systemd units for", known.Coord, ":", err)
+		}
+	}
+	return nil
 }
 
 func (pm *PodManager) RuntimeVersionReport(ctx context.Context) (*podman.DockerVersionReport, error) {
 	return pm.podman.Version(ctx)
 }
 
+// GetPod looks up one of our pods by its key
+func (pm *PodManager) GetPod(key string) (RunningPod, bool) {
+	pm.podsLock.RLock()
+	defer pm.podsLock.RUnlock()
+	known, ok := pm.KnownPods[key]
+	return known, ok
+}
+
+// SnapshotPods copies the pods we know about, so they can be looped over without holding our lock
+func (pm *PodManager) SnapshotPods() map[string]RunningPod {
+	pm.podsLock.RLock()
+	defer pm.podsLock.RUnlock()
+	snapshot := make(map[string]RunningPod, len(pm.KnownPods))
+	for key, known := range pm.KnownPods {
+		snapshot[key] = known
+	}
+	return snapshot
+}
+
 func (pm *PodManager) SetPodId(coord PodCoord, podId string) {
+	pm.podsLock.Lock()
+	defer pm.podsLock.Unlock()
 	if known, ok := pm.KnownPods[coord.Key()]; ok {
 		pm.KnownPods[coord.Key()] = RunningPod{known.Kube, coord, podId, known.ContainerIDs}
 		log.Println("Pods: Created pod", podId, "for", coord)
@@ -144,6 +239,8 @@ func (pm *PodManager) SetPodId(coord PodCoord, podId string) {
 }
 
 func (pm *PodManager) SetContainerIDs(coord PodCoord, containerIDs map[string]string) {
+	pm.podsLock.Lock()
+	defer pm.podsLock.Unlock()
 	if known, ok := pm.KnownPods[coord.Key()]; ok {
 		pm.KnownPods[coord.Key()] = RunningPod{known.Kube, coord, known.PodId, containerIDs}
 		log.Println("Pods: Have", len(containerIDs), "containers", containerIDs, "for", coord)
@@ -159,7 +256,8 @@ func (pm *PodManager) RegisterPod(pod *corev1.Pod) (PodCoord, error) {
 	}
 	log.Println("Pods:", podCoord, "registered")
 
-	// TODO: mutex
+	pm.podsLock.Lock()
+	defer pm.podsLock.Unlock()
 	if known, ok := pm.KnownPods[podCoord.Key()]; ok {
 		pm.KnownPods[podCoord.Key()] = RunningPod{pod, podCoord, known.PodId, known.ContainerIDs}
 	} else {
@@ -169,8 +267,9 @@ func (pm *PodManager) RegisterPod(pod *corev1.Pod) (PodCoord, error) {
 }
 
 func (pm *PodManager) UnregisterPod(podCoord PodCoord) error {
-	// TODO: mutex
+	pm.podsLock.Lock()
 	delete(pm.KnownPods, podCoord.Key())
+	pm.podsLock.Unlock()
 
 	err := pm.specStorage.RemovePod(podCoord)
 	if err != nil {
@@ -199,7 +298,7 @@ func (pm *PodManager) GetAllStats(ctx context.Context) (map[*metav1.ObjectMeta]m
 
 	// associate reports with k8s pod metadata
 	podMap := make(map[*metav1.ObjectMeta]map[string]*podman.ContainerStats)
-	for _, pod := range pm.KnownPods {
+	for _, pod := range pm.SnapshotPods() {
 		containerMap := make(map[string]*podman.ContainerStats, len(pod.ContainerIDs))
 		for conName, conID := range pod.ContainerIDs {
 			if conReport, ok := containerStats[conID]; ok {
@@ -217,6 +316,52 @@ func (pm *PodManager) GetAllStats(ctx context.Context) (map[*metav1.ObjectMeta]m
 func (pm *PodManager) StartExecInPod(ctx context.Context, podCoord PodCoord, containerName string, options *podman.ContainerExecOptions) (*podman.ExecSession, error) {
 	return pm.podman.ContainerExec(ctx, podCoord.ContainerKey(containerName), options)
 }
+
+// SavePreviousLogs keeps a copy of an exited container's log for when it's been replaced
+func (pm *PodManager) SavePreviousLogs(ctx context.Context, podCoord PodCoord, podUID types.UID, containerName string) error {
+	return pm.prevLogs.Save(ctx, pm.podman, podCoord, podUID, containerName)
+}
+func (pm *PodManager) PromotePreviousLogs(podUID types.UID, containerName string) error {
+	return pm.prevLogs.Promote(podUID, containerName)
+}
+func (pm *PodManager) RemovePreviousLogs(podUID types.UID) error {
+	return pm.prevLogs.Remove(podUID)
+}
+func (pm *PodManager) FetchPreviousLogs(podUID types.UID, containerName string) (io.ReadCloser, error) {
+	return pm.prevLogs.Open(podUID, containerName)
+}
 func (pm *PodManager) FetchContainerLogs(ctx context.Context, podCoord PodCoord, containerName string, options *podman.ContainerLogsOptions) (io.ReadCloser, error) {
 	return pm.podman.ContainerLogs(ctx, podCoord.ContainerKey(containerName), options)
 }
+func (pm *PodManager) AttachToContainer(ctx context.Context, podCoord PodCoord, containerName string, options *podman.ContainerAttachOptions) (io.Writer, io.ReadCloser, error) {
+	return pm.podman.ContainerAttach(ctx, podCoord.ContainerKey(containerName), options)
+}
+func (pm *PodManager) ResizeContainer(ctx context.Context, podCoord PodCoord, containerName string, width, height uint16) error {
+	return pm.podman.ContainerResize(ctx, podCoord.ContainerKey(containerName), width, height)
+}
+
+// InspectPodNetwork finds the pod's IP, if it got one, and a process which is inside the pod's network namespace
+func (pm *PodManager) InspectPodNetwork(ctx context.Context, podCoord PodCoord) (string, int, error) {
+	podInsp, err := pm.podman.PodInspect(ctx, podCoord.Key())
+	if err != nil {
+		return "", 0, err
+	}
+	infraInsp, err := pm.podman.ContainerInspect(ctx, podInsp.InfraContainerID, false)
+	if err != nil {
+		return "", 0, err
+	}
+	if infraInsp.State == nil || !infraInsp.State.Running {
+		return "", 0, fmt.Errorf("pod %v isn't running", podCoord)
+	}
+
+	var podIP string
+	if infraInsp.NetworkSettings != nil {
+		for _, network := range infraInsp.NetworkSettings.Networks {
+			if network.IPAddress != "" {
+				podIP = network.IPAddress
+				break
+			}
+		}
+	}
+	return podIP, infraInsp.State.Pid, nil
+}
@@ -0,0 +1,21 @@
+package pods
+
+import (
+	"time"
+
+	"github.com/danopia/kube-pet-node/pkg/metrics"
+)
+
+var (
+	podOperationDuration = metrics.NewHistogramVec("kube_pet_pod_operation_duration_seconds", "How long creating or deleting a pod took, including image pulls.", metrics.SlowBuckets, "operation")
+	podOperationFailures = metrics.NewCounterVec("kube_pet_pod_operation_failures_total", "Pod creates or deletes which failed.", "operation")
+	imagePullDuration    = metrics.NewHistogramVec("kube_pet_image_pull_duration_seconds", "How long image pulls took, by result.", metrics.SlowBuckets, "result")
+)
+
+// observePodOperation is deferred at the start of an operation
+func observePodOperation(operation string, startTime time.Time, err *error) {
+	podOperationDuration.Observe(time.Since(startTime).Seconds(), operation)
+	if *err != nil {
+		podOperationFailures.Inc(operation)
+	}
+}
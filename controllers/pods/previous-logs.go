@@ -0,0 +1,118 @@
+package pods
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/danopia/kube-pet-node/pkg/podman"
+)
+
+// PreviousLogStorage keeps the final log of each container before podman forgets it,
+// so that `kubectl logs --previous` has something to show after a container is replaced.
+// Logs live under the pod's UID, so a new pod with the same name never sees an old pod's logs.
+// Each container has two slots: the instance that most recently exited,
+// and the one that was actually replaced, which is what --previous shows.
+type PreviousLogStorage struct {
+	rootDir string
+}
+
+func NewPreviousLogStorage() (*PreviousLogStorage, error) {
+	cacheHome, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	rootDir := filepath.Join(cacheHome, "kube-pet-node", "previous-logs")
+
+	if err := os.MkdirAll(rootDir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &PreviousLogStorage{
+		rootDir: rootDir,
+	}, nil
+}
+
+func (pls *PreviousLogStorage) podDir(podUID types.UID) string {
+	return filepath.Join(pls.rootDir, string(podUID))
+}
+func (pls *PreviousLogStorage) exitedPath(podUID types.UID, containerName string) string {
+	return filepath.Join(pls.podDir(podUID), containerName+".exited.log")
+}
+func (pls *PreviousLogStorage) previousPath(podUID types.UID, containerName string) string {
+	return filepath.Join(pls.podDir(podUID), containerName+".previous.log")
+}
+
+// Save copies an exited container's log out of podman, with podman's timestamps on each line.
+// It only becomes the previous log once another instance of the container starts.
+func (pls *PreviousLogStorage) Save(ctx context.Context, podmanClient *podman.PodmanClient, coord PodCoord, podUID types.UID, containerName string) error {
+	logs, err := podmanClient.ContainerLogs(ctx, coord.ContainerKey(containerName), &podman.ContainerLogsOptions{
+		Timestamps: true,
+		Tail:       "10000", // plenty for figuring out why something crashed
+	})
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	if err := os.MkdirAll(pls.podDir(podUID), 0700); err != nil {
+		return err
+	}
+
+	// write it off to the side so that a failure doesn't clobber the last good copy
+	file, err := ioutil.TempFile(pls.podDir(podUID), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	// the demuxer closes the file for us (twice, which is fine)
+	if err := podman.DemuxRawStream(logs, file, file, true); err != nil && err != io.EOF {
+		return err
+	}
+	return os.Rename(file.Name(), pls.exitedPath(podUID, containerName))
+}
+
+// Promote is called when a container starts again,
+// so the instance that exited before it becomes the previous one
+func (pls *PreviousLogStorage) Promote(podUID types.UID, containerName string) error {
+	err := os.Rename(pls.exitedPath(podUID, containerName), pls.previousPath(podUID, containerName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (pls *PreviousLogStorage) Open(podUID types.UID, containerName string) (io.ReadCloser, error) {
+	return os.Open(pls.previousPath(podUID, containerName))
+}
+
+// Remove drops every log of a pod that's gone for good
+func (pls *PreviousLogStorage) Remove(podUID types.UID) error {
+	return os.RemoveAll(pls.podDir(podUID))
+}
+
+// Prune removes logs that nobody has replaced in a while
+func (pls *PreviousLogStorage) Prune(maxAge time.Duration) error {
+	files, err := ioutil.ReadDir(pls.rootDir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if time.Since(file.ModTime()) < maxAge {
+			continue
+		}
+		log.Println("Pods: Pruning old previous logs", file.Name())
+		if err := os.RemoveAll(filepath.Join(pls.rootDir, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -23,33 +23,49 @@ type PodmanProvider struct {
 	volumes *volumes.VolumesController
 	caching *caching.Controller
 	cniNet  string
+	// blank unless running against a rootless podman
+	rootlessNet string
 	// pods        map[string]*corev1.Pod
-	podNotifier func(*corev1.Pod)
+	podNotifier    func(*corev1.Pod)
+	staticNotifier func(*corev1.Pod)
 	// specStorage *PodSpecStorage
+
+	terminating terminatingPods
+	restarts    restartBackOffs
 }
 
-func NewPodmanProvider(podManager *PodManager, caching *caching.Controller, volumes *volumes.VolumesController, events record.EventRecorder, cniNet string) *PodmanProvider {
-	return &PodmanProvider{
+func NewPodmanProvider(podManager *PodManager, caching *caching.Controller, volumes *volumes.VolumesController, events record.EventRecorder, cniNet string, rootlessNet string) *PodmanProvider {
+	d := &PodmanProvider{
 		podman:  podManager.podman,
 		manager: podManager,
 		events:  events,
 		volumes: volumes,
 		caching: caching,
 		cniNet:  cniNet,
+
+		rootlessNet: rootlessNet,
 		// pods:        make(map[string]*corev1.Pod),
-		podNotifier: func(*corev1.Pod) {},
+		podNotifier:    func(*corev1.Pod) {},
+		staticNotifier: func(*corev1.Pod) {},
 		// specStorage: specStorage,
 	}
+	podManager.HandleEvents(d.handleContainerEvent)
+	return d
 }
 
 // CreatePod takes a Kubernetes Pod and deploys it within the provider.
-func (d *PodmanProvider) CreatePod(ctx context.Context, pod *corev1.Pod) error {
-	if val, ok := pod.Annotations["kubernetes.io/config.source"]; ok && val == "file" {
-		// A static pod, presumably from us; just ignore it for now
+func (d *PodmanProvider) CreatePod(ctx context.Context, pod *corev1.Pod) (err error) {
+	if IsStaticPod(pod) {
+		// A mirror of a static pod; the static pod itself is run from its manifest
 		log.Println("Pods: Received create for a static pod", pod.ObjectMeta.Name)
 		return nil
 	}
 
+	defer observePodOperation("create", time.Now(), &err)
+	return d.createPod(ctx, pod)
+}
+
+func (d *PodmanProvider) createPod(ctx context.Context, pod *corev1.Pod) error {
 	now := metav1.NewTime(time.Now())
 	pod.Status = corev1.PodStatus{
 		// HostIP: d.manager.NodeIP, // TODO!
@@ -72,7 +88,7 @@ func (d *PodmanProvider) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 			},
 		},
 	}
-	d.podNotifier(pod)
+	d.notifyPod(pod)
 
 	podCoord, err := d.manager.RegisterPod(pod)
 	if err != nil {
@@ -80,26 +96,20 @@ func (d *PodmanProvider) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 	}
 	// log.Println("Pods:", podCoord, "registered")
 
-	podRef := &corev1.ObjectReference{
-		APIVersion:      "v1",
-		Kind:            "Pod",
-		Namespace:       pod.ObjectMeta.Namespace,
-		Name:            pod.ObjectMeta.Name,
-		UID:             pod.ObjectMeta.UID,
-		ResourceVersion: pod.ObjectMeta.ResourceVersion,
-		// FieldPath:        "spec.containers{app}",
-	}
+	podRef := podRefOf(pod)
 
 	err = d.volumes.CreatePodVolumes(ctx, pod)
 	if err != nil {
 		log.Println("Pods: volumes create err", err)
+		d.events.Eventf(podRef, corev1.EventTypeWarning, FailedMountVolume, "MountVolume.SetUp failed: %v", err)
 		return err
 	}
 
 	dnsServer := net.ParseIP("10.6.0.10") // TODO!
-	creation, err := d.podman.PodCreate(ctx, ConvertPodConfig(pod, dnsServer, d.cniNet))
+	creation, err := d.podman.PodCreate(ctx, ConvertPodConfig(pod, dnsServer, d.cniNet, d.rootlessNet))
 	if err != nil {
 		log.Println("Pods: pod create err", err)
+		d.events.Eventf(podRef, corev1.EventTypeWarning, FailedCreatePodSandBox, "Failed to create pod sandbox: %v", err)
 		return err
 	}
 	d.manager.SetPodId(podCoord, creation.Id)
@@ -120,10 +130,11 @@ func (d *PodmanProvider) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 	}
 
 	for _, conSpec := range pod.Spec.Containers {
+		conRef := containerRef(podRef, conSpec.Name)
 
 		// Always pull first for Always
 		if conSpec.ImagePullPolicy == corev1.PullAlways {
-			err = d.PullImage(ctx, conSpec.Image, pullSecrets, podRef)
+			err = d.PullImage(ctx, conSpec.Image, pullSecrets, conRef)
 			if err != nil {
 				log.Println("Pods TODO: image pull", conSpec.Image, "err", err)
 				return err
@@ -136,7 +147,7 @@ func (d *PodmanProvider) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 			// Pull on-the-spot for IfNotPresent
 			if strings.HasSuffix(err.Error(), "no such image") && conSpec.ImagePullPolicy == corev1.PullIfNotPresent {
 
-				err := d.PullImage(ctx, conSpec.Image, pullSecrets, podRef)
+				err := d.PullImage(ctx, conSpec.Image, pullSecrets, conRef)
 				if err != nil {
 					// TODO: go into ImagePullBackoff
 					log.Println("Pods TODO: image pull", conSpec.Image, "err", err)
@@ -147,22 +158,24 @@ func (d *PodmanProvider) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 				creation, err = d.podman.ContainerCreate(ctx, ConvertContainerConfig(pod, &conSpec, creation.Id))
 				if err != nil {
 					log.Println("Pods: container create err", err)
+					d.events.Eventf(conRef, corev1.EventTypeWarning, FailedToCreateContainer, "Error: %v", err)
 					return err
 				}
 
 			} else {
 				log.Println("Pods: container create err", err)
+				d.events.Eventf(conRef, corev1.EventTypeWarning, FailedToCreateContainer, "Error: %v", err)
 				return err
 			}
 		}
 
 		// TODO: figure out what kinda stuff this would be
 		for _, warning := range creation.Warnings {
-			d.events.Eventf(podRef, corev1.EventTypeWarning, "CreationWarning", "Container %s: %s", conSpec.Name, warning)
+			d.events.Eventf(conRef, corev1.EventTypeWarning, "CreationWarning", "Container %s: %s", conSpec.Name, warning)
 		}
 
 		// log.Printf("Pods: container create %+v", conCreation)
-		d.events.Eventf(podRef, corev1.EventTypeNormal, "Created", "Created container %s", conSpec.Name)
+		d.events.Eventf(conRef, corev1.EventTypeNormal, CreatedContainer, "Created container %s", conSpec.Name)
 		containerIDs[conSpec.Name] = creation.Id
 	}
 
@@ -193,19 +206,20 @@ func (d *PodmanProvider) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 		})
 	}
 
-	d.podNotifier(pod)
+	d.notifyPod(pod)
 	d.manager.RegisterPod(pod)
 
 	startedTime := metav1.NewTime(time.Now())
 	_, err = d.podman.PodStart(ctx, creation.Id)
 	if err != nil {
 		log.Println("Pods: pod start err", err)
+		d.events.Eventf(podRef, corev1.EventTypeWarning, FailedToStartContainer, "Error: %v", err)
 		return err
 	}
 	// log.Printf("Pods: pod started %+v", msg)
 
 	pod.Status.Phase = corev1.PodRunning
-	d.podNotifier(pod)
+	d.notifyPod(pod)
 	d.manager.RegisterPod(pod)
 
 	containerInspects := make(map[string]*podman.InspectContainerData)
@@ -220,10 +234,22 @@ func (d *PodmanProvider) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 
 		if conName == "_infra" {
 			// Infra container; probably fill in pod networking info
-			if !pod.Spec.HostNetwork {
+			// rootless pods don't get an IP that the cluster could reach
+			if !pod.Spec.HostNetwork && d.rootlessNet == "" {
 				if infraNetwork, ok := conInsp.NetworkSettings.Networks[d.cniNet]; ok {
-					pod.Status.PodIP = infraNetwork.InspectBasicNetworkConfig.IPAddress
-					pod.Status.PodIPs = []corev1.PodIP{{IP: pod.Status.PodIP}}
+					// one address per family, with IPv4 as the primary when there's both
+					pod.Status.PodIPs = nil
+					for _, podIP := range []string{
+						infraNetwork.InspectBasicNetworkConfig.IPAddress,
+						infraNetwork.InspectBasicNetworkConfig.GlobalIPv6Address,
+					} {
+						if podIP != "" {
+							pod.Status.PodIPs = append(pod.Status.PodIPs, corev1.PodIP{IP: podIP})
+						}
+					}
+					if len(pod.Status.PodIPs) > 0 {
+						pod.Status.PodIP = pod.Status.PodIPs[0].IP
+					}
 				}
 			}
 		}
@@ -251,15 +277,23 @@ func (d *PodmanProvider) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 					StartedAt: startedTime,
 				},
 			}
-			d.events.Eventf(podRef, corev1.EventTypeNormal, "Started", "Started container %s", cs.Name)
+			d.events.Eventf(containerRef(podRef, cs.Name), corev1.EventTypeNormal, StartedContainer, "Started container %s", cs.Name)
 		} else {
 			log.Println("Pods Warn: failed to match container", cs.Name, "from", containerInspects)
 		}
 	}
 
-	d.podNotifier(pod)
+	d.notifyPod(pod)
 	d.manager.RegisterPod(pod)
 
+	if WantsSystemdUnits(pod) {
+		if err := d.manager.unitStorage.Install(ctx, d.podman, podCoord); err != nil {
+			// the pod is fine, it just won't come back by itself after a reboot
+			log.Println("Pods WARN: systemd units install err", err)
+			d.events.Eventf(podRef, corev1.EventTypeWarning, FailedSystemdUnits, "Failed to install systemd units: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -272,20 +306,49 @@ func (d *PodmanProvider) UpdatePod(ctx context.Context, pod *corev1.Pod) error {
 // DeletePod takes a Kubernetes Pod and deletes it from the provider. Once a pod is deleted, the provider is
 // expected to call the NotifyPods callback with a terminal pod status where all the containers are in a terminal
 // state, as well as the pod. DeletePod may be called multiple times for the same pod.
-func (d *PodmanProvider) DeletePod(ctx context.Context, pod *corev1.Pod) error {
+func (d *PodmanProvider) DeletePod(ctx context.Context, pod *corev1.Pod) (err error) {
 
-	if val, ok := pod.Annotations["kubernetes.io/config.source"]; ok && val == "file" {
-		// A static pod, presumably from us; just ignore it for now
+	if IsStaticPod(pod) {
+		// A mirror of a static pod; the static pod itself is removed with its manifest
 		log.Println("Pods: Received delete for a static pod", pod.ObjectMeta.Name)
 		return nil
 	}
 
+	defer observePodOperation("delete", time.Now(), &err)
+	return d.deletePod(ctx, pod)
+}
+
+func (d *PodmanProvider) deletePod(ctx context.Context, pod *corev1.Pod) error {
 	log.Println("Pods: delete", pod.ObjectMeta.Name)
 
 	key := pod.ObjectMeta.Namespace + "_" + pod.ObjectMeta.Name
+	podRef := podRefOf(pod)
+
+	// our containers are about to die, and that's fine
+	d.terminating.Add(key)
+	defer d.terminating.Remove(key)
+	defer d.restarts.Forget(PodCoord{pod.ObjectMeta.Namespace, pod.ObjectMeta.Name})
+
+	// systemd would fight us over stopping the pod
+	if err := d.manager.unitStorage.Remove(ctx, PodCoord{pod.ObjectMeta.Namespace, pod.ObjectMeta.Name}); err != nil {
+		log.Println("Pods: systemd units removal err", err)
+		return err
+	}
+
+	for _, conSpec := range pod.Spec.Containers {
+		d.events.Eventf(containerRef(podRef, conSpec.Name), corev1.EventTypeNormal, KillingContainer, "Stopping container %s", conSpec.Name)
+	}
+
+	// podman kills whatever is still running once the grace period is up, same as kubelet
+	gracePeriod := 30 * time.Second
+	if seconds := pod.ObjectMeta.DeletionGracePeriodSeconds; seconds != nil {
+		gracePeriod = time.Duration(*seconds) * time.Second
+	} else if seconds := pod.Spec.TerminationGracePeriodSeconds; seconds != nil {
+		gracePeriod = time.Duration(*seconds) * time.Second
+	}
 
 	now := metav1.NewTime(time.Now())
-	msg, err := d.podman.PodStop(ctx, key)
+	msg, err := d.podman.PodStopWithTimeout(ctx, key, gracePeriod)
 	if err != nil {
 		if err, ok := err.(*podman.ApiError); ok {
 			if err.Status == 404 {
@@ -295,8 +358,12 @@ func (d *PodmanProvider) DeletePod(ctx context.Context, pod *corev1.Pod) error {
 		}
 
 		log.Println("Pods: pod stop err", err)
+		d.events.Eventf(podRef, corev1.EventTypeWarning, FailedKillPod, "error killing pod: %v", err)
 		return err
 	}
+	if time.Since(now.Time) > gracePeriod {
+		d.events.Eventf(podRef, corev1.EventTypeWarning, ExceededGracePeriod, "Container runtime did not kill the pod within specified grace period.")
+	}
 	// log.Printf("Pods: pod stopped %+v", msg)
 
 	pod.Status.Conditions = []corev1.PodCondition{
@@ -329,7 +396,12 @@ func (d *PodmanProvider) DeletePod(ctx context.Context, pod *corev1.Pod) error {
 		}
 	}
 
-	d.podNotifier(pod)
+	d.notifyPod(pod)
+
+	// nothing can ask for this pod's previous logs once its UID is gone
+	if err := d.manager.RemovePreviousLogs(pod.ObjectMeta.UID); err != nil {
+		log.Println("Pods WARN: Failed to remove previous logs of", key, ":", err)
+	}
 
 	err = d.manager.UnregisterPod(PodCoord{pod.ObjectMeta.Namespace, pod.ObjectMeta.Name})
 	if err != nil {
@@ -361,7 +433,8 @@ func (d *PodmanProvider) GetPod(ctx context.Context, namespace, name string) (*c
 	log.Println("Pods: get pod", namespace, name)
 
 	key := namespace + "_" + name
-	return d.manager.KnownPods[key].Kube, nil
+	known, _ := d.manager.GetPod(key)
+	return known.Kube, nil
 }
 
 // GetPodStatus retrieves the status of a pod by name from the provider.
@@ -382,7 +455,11 @@ func (d *PodmanProvider) GetPods(context.Context) ([]*corev1.Pod, error) {
 	log.Println("Pods: list pods")
 
 	pods := make([]*corev1.Pod, 0)
-	for _, pod := range d.manager.KnownPods {
+	for _, pod := range d.manager.SnapshotPods() {
+		// Static pods don't exist in the API, so keep virtual-kubelet from reaping them
+		if IsStaticPod(pod.Kube) {
+			continue
+		}
 		pods = append(pods, pod.Kube)
 	}
 	return pods, nil
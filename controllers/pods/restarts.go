@@ -0,0 +1,179 @@
+package pods
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// kubelet's crash loop back-off: double the wait after each restart,
+// and forget about it once the container stays up for a while
+const (
+	initialRestartBackOff = 10 * time.Second
+	maxRestartBackOff     = 5 * time.Minute
+	restartBackOffReset   = 2 * maxRestartBackOff
+)
+
+// shouldRestart applies the pod's RestartPolicy to a container that exited
+func shouldRestart(pod *corev1.Pod, exitCode int) bool {
+	switch pod.Spec.RestartPolicy {
+	case corev1.RestartPolicyNever:
+		return false
+	case corev1.RestartPolicyOnFailure:
+		return exitCode != 0
+	default:
+		return true
+	}
+}
+
+type restartBackOffEntry struct {
+	delay     time.Duration
+	startedAt time.Time
+}
+
+// restartBackOffs tracks how long each container waits before its next restart
+type restartBackOffs struct {
+	lock    sync.Mutex
+	entries map[string]restartBackOffEntry
+}
+
+// Started notes when a container came up, which is how long-running containers earn a reset
+func (rb *restartBackOffs) Started(conKey string) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	if rb.entries == nil {
+		rb.entries = make(map[string]restartBackOffEntry)
+	}
+	entry := rb.entries[conKey]
+	entry.startedAt = time.Now()
+	rb.entries[conKey] = entry
+}
+
+// Next says how long to wait before restarting a container that just exited
+func (rb *restartBackOffs) Next(conKey string) time.Duration {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	if rb.entries == nil {
+		rb.entries = make(map[string]restartBackOffEntry)
+	}
+	entry := rb.entries[conKey]
+	if entry.delay == 0 || time.Since(entry.startedAt) > restartBackOffReset {
+		entry.delay = initialRestartBackOff
+	} else if entry.delay *= 2; entry.delay > maxRestartBackOff {
+		entry.delay = maxRestartBackOff
+	}
+	rb.entries[conKey] = entry
+	return entry.delay
+}
+
+// Forget drops the back-off of every container in a pod
+func (rb *restartBackOffs) Forget(podCoord PodCoord) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	for conKey := range rb.entries {
+		if strings.HasPrefix(conKey, podCoord.Key()+"_") {
+			delete(rb.entries, conKey)
+		}
+	}
+}
+
+// containerExited puts a container's exit into the pod status.
+// One that's about to be restarted shows as waiting out its back-off, like kubelet's CrashLoopBackOff,
+// with how it exited kept as its last state.
+func (d *PodmanProvider) containerExited(pod *corev1.Pod, containerName string, terminated *corev1.ContainerStateTerminated, backOff time.Duration) {
+	pod = pod.DeepCopy()
+	for idx := range pod.Status.ContainerStatuses {
+		cs := &pod.Status.ContainerStatuses[idx]
+		if cs.Name != containerName {
+			continue
+		}
+		if cs.State.Running != nil {
+			terminated.StartedAt = cs.State.Running.StartedAt
+		}
+		cs.Ready = false
+		if backOff > 0 {
+			cs.LastTerminationState = corev1.ContainerState{Terminated: terminated}
+			cs.State = corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{
+					Reason:  "CrashLoopBackOff",
+					Message: fmt.Sprintf("back-off %v restarting failed container=%s pod=%s_%s(%s)", backOff, containerName, pod.ObjectMeta.Name, pod.ObjectMeta.Namespace, pod.ObjectMeta.UID),
+				},
+			}
+		} else {
+			cs.State = corev1.ContainerState{Terminated: terminated}
+		}
+	}
+	setPodReady(pod)
+	d.notifyPod(pod)
+	d.manager.RegisterPod(pod)
+}
+
+// setPodReady keeps the pod's Ready condition in line with its containers
+func setPodReady(pod *corev1.Pod) {
+	status := corev1.ConditionTrue
+	for _, cs := range pod.Status.ContainerStatuses {
+		if !cs.Ready {
+			status = corev1.ConditionFalse
+		}
+	}
+	for idx := range pod.Status.Conditions {
+		cond := &pod.Status.Conditions[idx]
+		if cond.Type == corev1.PodReady && cond.Status != status {
+			cond.Status = status
+			cond.LastTransitionTime = metav1.NewTime(time.Now())
+		}
+	}
+}
+
+// restartContainer starts an exited container again once its back-off is up,
+// unless the pod was deleted or replaced in the meantime
+func (d *PodmanProvider) restartContainer(podCoord PodCoord, pod *corev1.Pod, containerName string, terminated *corev1.ContainerStateTerminated) {
+	conKey := podCoord.ContainerKey(containerName)
+	conRef := containerRef(podRefOf(pod), containerName)
+
+	delay := d.restarts.Next(conKey)
+	log.Println("Pods: Restarting", conKey, "in", delay)
+	d.events.Eventf(conRef, corev1.EventTypeWarning, BackOffStartContainer, "Back-off restarting failed container")
+	d.containerExited(pod, containerName, terminated, delay)
+
+	time.AfterFunc(delay, func() {
+		known, ok := d.manager.GetPod(podCoord.Key())
+		if !ok || known.Kube == nil || known.Kube.ObjectMeta.UID != pod.ObjectMeta.UID || d.terminating.Has(podCoord.Key()) {
+			return
+		}
+
+		if err := d.podman.ContainerStart(context.TODO(), conKey); err != nil {
+			log.Println("Pods WARN: container restart err", err)
+			d.events.Eventf(conRef, corev1.EventTypeWarning, FailedToStartContainer, "Error: %v", err)
+			return
+		}
+		d.events.Eventf(conRef, corev1.EventTypeNormal, StartedContainer, "Started container %s", containerName)
+
+		pod := known.Kube.DeepCopy()
+		for idx := range pod.Status.ContainerStatuses {
+			cs := &pod.Status.ContainerStatuses[idx]
+			if cs.Name == containerName {
+				// while backing off, the last state is already in place
+				if cs.State.Terminated != nil {
+					cs.LastTerminationState = cs.State
+				}
+				cs.Ready = true
+				cs.RestartCount++
+				cs.State = corev1.ContainerState{
+					Running: &corev1.ContainerStateRunning{
+						StartedAt: metav1.NewTime(time.Now()),
+					},
+				}
+			}
+		}
+		setPodReady(pod)
+		d.notifyPod(pod)
+		d.manager.RegisterPod(pod)
+	})
+}
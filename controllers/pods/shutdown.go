@@ -0,0 +1,47 @@
+package pods
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// StopAllPods stops every pod in parallel because the machine is going down,
+// giving each one its own termination grace period but no more than gracePeriod.
+// Pods with systemd units are left for systemd, which stops them during shutdown anyway.
+func (d *PodmanProvider) StopAllPods(ctx context.Context, gracePeriod time.Duration) {
+	var wg sync.WaitGroup
+	for key, known := range d.manager.SnapshotPods() {
+		if known.Kube == nil || known.PodId == "" || WantsSystemdUnits(known.Kube) {
+			continue
+		}
+
+		timeout := gracePeriod
+		if seconds := known.Kube.Spec.TerminationGracePeriodSeconds; seconds != nil && time.Duration(*seconds)*time.Second < timeout {
+			timeout = time.Duration(*seconds) * time.Second
+		}
+
+		wg.Add(1)
+		go func(key string, pod *corev1.Pod, timeout time.Duration) {
+			defer wg.Done()
+			podRef := podRefOf(pod)
+
+			// our containers are about to die, and that's fine
+			d.terminating.Add(key)
+			defer d.terminating.Remove(key)
+
+			log.Println("Pods: Stopping", key, "for node shutdown within", timeout)
+			for _, conSpec := range pod.Spec.Containers {
+				d.events.Eventf(containerRef(podRef, conSpec.Name), corev1.EventTypeNormal, KillingContainer, "Stopping container %s", conSpec.Name)
+			}
+			if _, err := d.podman.PodStopWithTimeout(ctx, key, timeout); err != nil {
+				log.Println("Pods: pod stop err", err)
+				d.events.Eventf(podRef, corev1.EventTypeWarning, FailedKillPod, "error killing pod: %v", err)
+			}
+		}(key, known.Kube, timeout)
+	}
+	wg.Wait()
+}
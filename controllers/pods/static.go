@@ -0,0 +1,57 @@
+package pods
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The annotations kubelet uses to tie static pods to their API mirrors
+const ConfigSourceAnnotation = "kubernetes.io/config.source"
+const ConfigHashAnnotation = "kubernetes.io/config.hash"
+const ConfigMirrorAnnotation = "kubernetes.io/config.mirror"
+
+// IsStaticPod is true for pods that come from a local source (a manifest file)
+// and also for their mirrors in the API, which carry the same annotation
+func IsStaticPod(pod *corev1.Pod) bool {
+	source, ok := pod.ObjectMeta.Annotations[ConfigSourceAnnotation]
+	return ok && source == "file"
+}
+
+// IsMirrorPod is true for the API's read-only copy of a static pod
+func IsMirrorPod(pod *corev1.Pod) bool {
+	_, ok := pod.ObjectMeta.Annotations[ConfigMirrorAnnotation]
+	return ok
+}
+
+// CreateStaticPod runs a pod from a local manifest through the normal podman path.
+// Status updates for these pods go to the NotifyStaticPods callback instead of virtual-kubelet.
+func (d *PodmanProvider) CreateStaticPod(ctx context.Context, pod *corev1.Pod) error {
+	return d.createPod(ctx, pod)
+}
+
+// DeleteStaticPod stops and removes a pod which was started by CreateStaticPod.
+func (d *PodmanProvider) DeleteStaticPod(ctx context.Context, pod *corev1.Pod) error {
+	return d.deletePod(ctx, pod)
+}
+
+func (d *PodmanProvider) NotifyStaticPods(notifier func(*corev1.Pod)) {
+	d.staticNotifier = notifier
+}
+
+// notifyPod sends status to whoever is responsible for reporting the pod to the API
+func (d *PodmanProvider) notifyPod(pod *corev1.Pod) {
+	if IsStaticPod(pod) {
+		d.staticNotifier(pod)
+	} else {
+		d.podNotifier(pod)
+	}
+}
+
+// The fake pod which stands in for the node itself, see EnsureStaticPods
+const HostPodNamespace = "kube-pets"
+const HostContainerName = "pet-host"
+
+func HostPodName(nodeName string) string {
+	return "host-" + nodeName
+}
@@ -0,0 +1,153 @@
+package pods
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/danopia/kube-pet-node/pkg/podman"
+)
+
+// Pods with this annotation set to "true" also get enabled as systemd units,
+// so that they come back at boot even if kube-pet-node isn't working
+const SystemdUnitsAnnotation = "vk.podman.io/systemd-units"
+
+func WantsSystemdUnits(pod *corev1.Pod) bool {
+	value, ok := pod.ObjectMeta.Annotations[SystemdUnitsAnnotation]
+	return ok && value == "true"
+}
+
+// PodUnitStorage keeps the generated unit files for each pod in its own directory.
+// systemd links to the files here instead of getting copies, so removing them is simple.
+type PodUnitStorage struct {
+	rootDir string
+
+	// rootless podman's units belong to the user's systemd instead
+	userUnits bool
+}
+
+func NewPodUnitStorage(userUnits bool) (*PodUnitStorage, error) {
+	cacheHome, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	rootDir := filepath.Join(cacheHome, "kube-pet-node", "unit-files")
+
+	// systemd reads these as root, or as us for user units, so nothing special is needed
+	if err := os.MkdirAll(rootDir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &PodUnitStorage{
+		rootDir:   rootDir,
+		userUnits: userUnits,
+	}, nil
+}
+
+func (pus *PodUnitStorage) ListAllPods() ([]PodCoord, error) {
+	files, err := ioutil.ReadDir(pus.rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]PodCoord, 0, len(files))
+	for _, file := range files {
+		if podCoord, ok := ParsePodKey(file.Name()); ok && file.IsDir() {
+			list = append(list, podCoord)
+		}
+	}
+	return list, nil
+}
+
+func (pus *PodUnitStorage) HasUnits(coord PodCoord) bool {
+	_, err := os.Stat(filepath.Join(pus.rootDir, coord.Key()))
+	return err == nil
+}
+
+// Install has podman generate units for an existing pod and enables them for the next boot.
+// The units aren't started because the pod is already running.
+func (pus *PodUnitStorage) Install(ctx context.Context, podmanClient *podman.PodmanClient, coord PodCoord) error {
+	units, err := podmanClient.GenerateSystemd(ctx, coord.Key(), &podman.GenerateSystemdOptions{
+		RestartPolicy: "on-failure",
+	})
+	if err != nil {
+		return err
+	}
+	if len(units) == 0 {
+		return fmt.Errorf("podman didn't generate any units for %v", coord)
+	}
+
+	podDir := filepath.Join(pus.rootDir, coord.Key())
+	if err := os.MkdirAll(podDir, 0700); err != nil {
+		return err
+	}
+
+	unitPaths := make([]string, 0, len(units))
+	for unitName, unitBody := range units {
+		unitPath := filepath.Join(podDir, unitName+".service")
+		if err := ioutil.WriteFile(unitPath, []byte(unitBody), 0644); err != nil {
+			return err
+		}
+		unitPaths = append(unitPaths, unitPath)
+	}
+	sort.Strings(unitPaths)
+
+	if err := pus.systemctl(ctx, append([]string{"enable"}, unitPaths...)...); err != nil {
+		return err
+	}
+	log.Println("Pods: Enabled systemd units", unitPaths, "for", coord)
+	return nil
+}
+
+// Remove stops and disables a pod's units, if it has any, and then deletes them.
+// This has to happen before the pod is stopped, otherwise systemd might restart it.
+func (pus *PodUnitStorage) Remove(ctx context.Context, coord PodCoord) error {
+	podDir := filepath.Join(pus.rootDir, coord.Key())
+	files, err := ioutil.ReadDir(podDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	unitNames := make([]string, 0, len(files))
+	for _, file := range files {
+		unitNames = append(unitNames, file.Name())
+	}
+	sort.Strings(unitNames)
+
+	if len(unitNames) > 0 {
+		// don't get stuck on units which systemd already forgot about
+		if err := pus.systemctl(ctx, append([]string{"disable", "--now"}, unitNames...)...); err != nil {
+			log.Println("Pods WARN: Failed to disable systemd units for", coord, ":", err)
+		} else {
+			log.Println("Pods: Disabled systemd units", unitNames, "for", coord)
+		}
+	}
+
+	return os.RemoveAll(podDir)
+}
+
+func (pus *PodUnitStorage) systemctl(ctx context.Context, args ...string) error {
+	var cmd *exec.Cmd
+	if pus.userUnits {
+		cmd = exec.CommandContext(ctx, "systemctl", append([]string{"--user"}, args...)...)
+	} else if os.Getuid() == 0 {
+		cmd = exec.CommandContext(ctx, "systemctl", args...)
+	} else {
+		cmd = exec.CommandContext(ctx, "sudo", append([]string{"systemctl"}, args...)...)
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("systemctl %v failed: %v: %s", args[0], err, out)
+	}
+	return nil
+}
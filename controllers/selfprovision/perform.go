@@ -105,7 +105,7 @@ func (ctl *Controller) Perform(ctx context.Context) error {
 				return err
 			}
 
-			cniTar, err := fsinject.StartArchiveExtraction(ctx, "/etc/cni/net.d")
+			cniTar, err := fsinject.StartArchiveExtraction(ctx, "/etc/cni/net.d", true)
 			if err != nil {
 				return err
 			}
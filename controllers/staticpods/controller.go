@@ -0,0 +1,169 @@
+package staticpods
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubernetes "k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"github.com/danopia/kube-pet-node/controllers/pods"
+)
+
+// StaticPodsController runs pods from a manifest directory, like kubelet's --pod-manifest-path,
+// and keeps a mirror of each one in the API so that the cluster can see them.
+type StaticPodsController struct {
+	nodeName    string
+	nodeUID     types.UID
+	manifestDir string
+
+	coreV1   corev1client.CoreV1Interface
+	provider *pods.PodmanProvider
+
+	// latest copy of each static pod we're running, including status
+	lock    sync.Mutex
+	running map[string]*corev1.Pod
+	statusC chan struct{}
+}
+
+func NewStaticPodsController(nodeName string, manifestDir string, kubernetes *kubernetes.Clientset, podManager *pods.PodManager, provider *pods.PodmanProvider) *StaticPodsController {
+
+	log.Println("StaticPods: constructing controller")
+
+	// adopt static pods that were already running before we started
+	running := make(map[string]*corev1.Pod)
+	for key, pod := range podManager.SnapshotPods() {
+		if pods.IsStaticPod(pod.Kube) {
+			running[key] = pod.Kube
+		}
+	}
+
+	spc := &StaticPodsController{
+		nodeName:    nodeName,
+		manifestDir: manifestDir,
+		coreV1:      kubernetes.CoreV1(),
+		provider:    provider,
+		running:     running,
+		statusC:     make(chan struct{}, 1),
+	}
+	provider.NotifyStaticPods(spc.receiveStatus)
+	return spc
+}
+
+// Run doesn't need the API server to be reachable; mirrors catch up whenever it is
+func (spc *StaticPodsController) Run(ctx context.Context) {
+	log.Println("StaticPods: Watching", spc.manifestDir, "for pod manifests")
+
+	// kubelet's default --file-check-frequency
+	ticker := time.NewTicker(20 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		spc.SyncManifests(ctx)
+		spc.SyncMirrors(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Println("StaticPods: Stopped watching manifests")
+			return
+		case <-ticker.C:
+		case <-spc.statusC:
+		}
+	}
+}
+
+// SyncManifests starts, stops, and replaces pods to match the manifest directory
+func (spc *StaticPodsController) SyncManifests(ctx context.Context) {
+	manifests, err := ReadManifestDir(spc.manifestDir, spc.nodeName)
+	if err != nil {
+		log.Println("StaticPods WARN: Failed to read manifests:", err)
+		return
+	}
+
+	// stop pods whose manifest went away or changed
+	for key, pod := range spc.runningPods() {
+		if wanted, ok := manifests[key]; ok && wanted.ObjectMeta.UID == pod.ObjectMeta.UID {
+			continue
+		}
+
+		log.Println("StaticPods: Stopping", key, "because its manifest changed or was removed")
+		if err := spc.provider.DeleteStaticPod(ctx, pod.DeepCopy()); err != nil {
+			log.Println("StaticPods WARN: Failed to stop", key, ":", err)
+			continue
+		}
+		spc.forget(key)
+	}
+
+	// start pods that we aren't running yet
+	for key, pod := range manifests {
+		if spc.isRunning(key) {
+			continue
+		}
+
+		log.Println("StaticPods: Starting", key, "from manifest")
+		spc.track(key, pod)
+		if err := spc.provider.CreateStaticPod(ctx, pod); err != nil {
+			log.Println("StaticPods WARN: Failed to start", key, ":", err)
+
+			// clean up whatever got created so the next sync can retry from scratch
+			if err := spc.provider.DeleteStaticPod(ctx, pod.DeepCopy()); err != nil {
+				log.Println("StaticPods WARN: Failed to clean up", key, ":", err)
+				continue
+			}
+			spc.forget(key)
+		}
+	}
+}
+
+func (spc *StaticPodsController) receiveStatus(pod *corev1.Pod) {
+	key := pod.ObjectMeta.Namespace + "_" + pod.ObjectMeta.Name
+
+	spc.lock.Lock()
+	if _, ok := spc.running[key]; !ok {
+		// probably a final status for a pod we just stopped
+		spc.lock.Unlock()
+		return
+	}
+	spc.running[key] = pod.DeepCopy()
+	spc.lock.Unlock()
+
+	// poke the loop without blocking the provider
+	select {
+	case spc.statusC <- struct{}{}:
+	default:
+	}
+}
+
+func (spc *StaticPodsController) runningPods() map[string]*corev1.Pod {
+	spc.lock.Lock()
+	defer spc.lock.Unlock()
+
+	snapshot := make(map[string]*corev1.Pod, len(spc.running))
+	for key, pod := range spc.running {
+		snapshot[key] = pod
+	}
+	return snapshot
+}
+
+func (spc *StaticPodsController) isRunning(key string) bool {
+	spc.lock.Lock()
+	defer spc.lock.Unlock()
+	_, ok := spc.running[key]
+	return ok
+}
+
+func (spc *StaticPodsController) track(key string, pod *corev1.Pod) {
+	spc.lock.Lock()
+	defer spc.lock.Unlock()
+	spc.running[key] = pod
+}
+
+func (spc *StaticPodsController) forget(key string) {
+	spc.lock.Lock()
+	defer spc.lock.Unlock()
+	delete(spc.running, key)
+}
@@ -0,0 +1,136 @@
+package staticpods
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/danopia/kube-pet-node/controllers/pods"
+)
+
+// ReadManifestDir loads every pod manifest in a directory, keyed by namespace_name.
+// Like kubelet, files starting with a dot are ignored.
+func ReadManifestDir(dir string, nodeName string) (map[string]*corev1.Pod, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := make(map[string]*corev1.Pod, len(files))
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		filePath := filepath.Join(dir, file.Name())
+
+		pod, err := ReadManifestFile(filePath, nodeName)
+		if err != nil {
+			log.Println("StaticPods WARN: Skipping manifest", filePath, "because", err)
+			continue
+		}
+
+		key := pod.ObjectMeta.Namespace + "_" + pod.ObjectMeta.Name
+		if _, exists := manifests[key]; exists {
+			log.Println("StaticPods WARN: Skipping manifest", filePath, "because", key, "was already defined")
+			continue
+		}
+		manifests[key] = pod
+	}
+	return manifests, nil
+}
+
+// ReadManifestFile decodes a single YAML or JSON pod and fills in what the API server would have
+func ReadManifestFile(filePath string, nodeName string) (*corev1.Pod, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var pod corev1.Pod
+	if err := yaml.NewYAMLOrJSONDecoder(file, 4096).Decode(&pod); err != nil {
+		return nil, err
+	}
+	if pod.Kind != "" && pod.Kind != "Pod" {
+		return nil, fmt.Errorf("kind %s isn't supported, only Pod", pod.Kind)
+	}
+	if pod.ObjectMeta.Name == "" {
+		return nil, fmt.Errorf("pod is missing a name")
+	}
+
+	// hash the manifest as written so that any edit replaces the pod
+	hash, err := hashManifest(&pod, nodeName, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	applyDefaults(&pod, nodeName, hash)
+	return &pod, nil
+}
+
+func hashManifest(pod *corev1.Pod, nodeName string, filePath string) (string, error) {
+	podJson, err := json.Marshal(pod)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := md5.New()
+	hasher.Write(podJson)
+	fmt.Fprintf(hasher, "host:%s", nodeName)
+	fmt.Fprintf(hasher, "file:%s", filePath)
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Via https://github.com/kubernetes/kubernetes/blob/master/pkg/kubelet/config/common.go
+// plus the handful of API defaults that the rest of kube-pet-node relies on
+func applyDefaults(pod *corev1.Pod, nodeName string, hash string) {
+	pod.ObjectMeta.UID = types.UID(hash)
+	pod.ObjectMeta.Name = pod.ObjectMeta.Name + "-" + strings.ToLower(nodeName)
+	if pod.ObjectMeta.Namespace == "" {
+		pod.ObjectMeta.Namespace = metav1.NamespaceDefault
+	}
+	pod.ObjectMeta.CreationTimestamp = metav1.Now()
+	pod.Spec.NodeName = nodeName
+
+	if pod.ObjectMeta.Annotations == nil {
+		pod.ObjectMeta.Annotations = make(map[string]string)
+	}
+	pod.ObjectMeta.Annotations[pods.ConfigHashAnnotation] = hash
+	pod.ObjectMeta.Annotations[pods.ConfigSourceAnnotation] = "file"
+
+	if pod.Spec.SecurityContext == nil {
+		pod.Spec.SecurityContext = &corev1.PodSecurityContext{}
+	}
+	if pod.Spec.RestartPolicy == "" {
+		pod.Spec.RestartPolicy = corev1.RestartPolicyAlways
+	}
+	if pod.Spec.DNSPolicy == "" {
+		pod.Spec.DNSPolicy = corev1.DNSClusterFirst
+	}
+	if pod.Spec.TerminationGracePeriodSeconds == nil {
+		gracePeriod := int64(corev1.DefaultTerminationGracePeriodSeconds)
+		pod.Spec.TerminationGracePeriodSeconds = &gracePeriod
+	}
+	for idx := range pod.Spec.Containers {
+		conSpec := &pod.Spec.Containers[idx]
+		if conSpec.ImagePullPolicy == "" {
+			imageName := conSpec.Image[strings.LastIndex(conSpec.Image, "/")+1:]
+			if strings.HasSuffix(imageName, ":latest") || !strings.ContainsAny(imageName, ":@") {
+				conSpec.ImagePullPolicy = corev1.PullAlways
+			} else {
+				conSpec.ImagePullPolicy = corev1.PullIfNotPresent
+			}
+		}
+	}
+}
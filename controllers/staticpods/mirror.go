@@ -0,0 +1,134 @@
+package staticpods
+
+import (
+	"context"
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+
+	"github.com/danopia/kube-pet-node/controllers/pods"
+)
+
+// SyncMirrors makes the API's mirror pods match what we're actually running.
+// Every failure here is retried on the next sync, so the API being down is fine.
+func (spc *StaticPodsController) SyncMirrors(ctx context.Context) {
+	if spc.nodeUID == "" {
+		node, err := spc.coreV1.Nodes().Get(ctx, spc.nodeName, metav1.GetOptions{})
+		if err != nil {
+			log.Println("StaticPods: Can't look up our Node yet, mirror pods will wait:", err)
+			return
+		}
+		spc.nodeUID = node.ObjectMeta.UID
+	}
+
+	for key, pod := range spc.runningPods() {
+		if err := spc.ensureMirror(ctx, pod); err != nil {
+			log.Println("StaticPods WARN: Failed to sync mirror pod for", key, ":", err)
+		}
+	}
+
+	if err := spc.deleteOrphanMirrors(ctx); err != nil {
+		log.Println("StaticPods WARN: Failed to clean up mirror pods:", err)
+	}
+}
+
+func (spc *StaticPodsController) ensureMirror(ctx context.Context, pod *corev1.Pod) error {
+	podApi := spc.coreV1.Pods(pod.ObjectMeta.Namespace)
+	hash := pod.ObjectMeta.Annotations[pods.ConfigHashAnnotation]
+
+	mirror, err := podApi.Get(ctx, pod.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		mirror = nil
+
+	} else if mirror.ObjectMeta.Annotations[pods.ConfigMirrorAnnotation] != hash {
+		// left over from an older manifest, or it's not a mirror at all
+		log.Println("StaticPods: Replacing outdated mirror pod", mirror.ObjectMeta.Namespace, mirror.ObjectMeta.Name)
+		if err := spc.deleteMirror(ctx, mirror); err != nil {
+			return err
+		}
+		mirror = nil
+	}
+
+	if mirror == nil {
+		mirror, err = podApi.Create(ctx, spc.buildMirror(pod), metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		log.Println("StaticPods: Created mirror pod", mirror.ObjectMeta.Namespace, mirror.ObjectMeta.Name)
+	}
+
+	if equality.Semantic.DeepEqual(mirror.Status, pod.Status) {
+		return nil
+	}
+	pod.Status.DeepCopyInto(&mirror.Status)
+	_, err = podApi.UpdateStatus(ctx, mirror, metav1.UpdateOptions{})
+	return err
+}
+
+func (spc *StaticPodsController) buildMirror(pod *corev1.Pod) *corev1.Pod {
+	mirror := &corev1.Pod{}
+	pod.ObjectMeta.DeepCopyInto(&mirror.ObjectMeta)
+	pod.Spec.DeepCopyInto(&mirror.Spec)
+
+	// the API assigns its own identity to the mirror
+	mirror.ObjectMeta.UID = ""
+	mirror.ObjectMeta.ResourceVersion = ""
+	mirror.ObjectMeta.CreationTimestamp = metav1.Time{}
+
+	mirror.ObjectMeta.Annotations[pods.ConfigMirrorAnnotation] = pod.ObjectMeta.Annotations[pods.ConfigHashAnnotation]
+	mirror.ObjectMeta.OwnerReferences = []metav1.OwnerReference{
+		{
+			APIVersion: "v1",
+			Controller: &[]bool{true}[0],
+			Kind:       "Node",
+			Name:       spc.nodeName,
+			UID:        spc.nodeUID,
+		},
+	}
+	return mirror
+}
+
+func (spc *StaticPodsController) deleteOrphanMirrors(ctx context.Context) error {
+	podList, err := spc.coreV1.Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", spc.nodeName).String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for idx := range podList.Items {
+		mirror := &podList.Items[idx]
+		if !pods.IsMirrorPod(mirror) || mirror.ObjectMeta.DeletionTimestamp != nil {
+			continue
+		}
+
+		key := mirror.ObjectMeta.Namespace + "_" + mirror.ObjectMeta.Name
+		if spc.isRunning(key) {
+			continue
+		}
+
+		log.Println("StaticPods: Deleting mirror pod", key, "which has no static pod")
+		if err := spc.deleteMirror(ctx, mirror); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (spc *StaticPodsController) deleteMirror(ctx context.Context, mirror *corev1.Pod) error {
+	err := spc.coreV1.Pods(mirror.ObjectMeta.Namespace).Delete(ctx, mirror.ObjectMeta.Name, metav1.DeleteOptions{
+		GracePeriodSeconds: &[]int64{0}[0],
+		Preconditions:      metav1.NewUIDPreconditions(string(mirror.ObjectMeta.UID)),
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -23,9 +23,12 @@ type VolumesController struct {
 	coreV1  corev1client.CoreV1Interface
 	caching *caching.Controller
 	podman  *podman.PodmanClient
+
+	// rootless podman volumes belong to us, so they don't need sudo
+	rootless bool
 }
 
-func NewVolumesController(kubernetes *kubernetes.Clientset, caching *caching.Controller, podman *podman.PodmanClient) *VolumesController {
+func NewVolumesController(kubernetes *kubernetes.Clientset, caching *caching.Controller, podman *podman.PodmanClient, rootless bool) *VolumesController {
 
 	log.Println("Volumes: constructing controller")
 
@@ -33,6 +36,8 @@ func NewVolumesController(kubernetes *kubernetes.Clientset, caching *caching.Con
 		coreV1:  kubernetes.CoreV1(),
 		caching: caching,
 		podman:  podman,
+
+		rootless: rootless,
 	}
 }
 
@@ -51,7 +51,7 @@ func (ctl *VolumesController) CreateProjectedVolume(ctx context.Context, pod *co
 		return err
 	}
 
-	tar, err := fsinject.StartArchiveExtraction(ctx, volPath)
+	tar, err := fsinject.StartArchiveExtraction(ctx, volPath, !ctl.rootless)
 	if err != nil {
 		return err
 	}
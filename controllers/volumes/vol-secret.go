@@ -31,7 +31,7 @@ func (ctl *VolumesController) CreateSecretVolume(ctx context.Context, podMeta *m
 	}
 	resVersion := secret.ObjectMeta.ResourceVersion
 
-	tar, err := fsinject.StartArchiveExtraction(ctx, volPath)
+	tar, err := fsinject.StartArchiveExtraction(ctx, volPath, !ctl.rootless)
 	if err != nil {
 		return err
 	}
@@ -27,6 +27,9 @@ case "$1" in
                 chmod -R 0700 /opt/kube-pet-node/.cache
         fi
 
+        # allow reading the kernel log for the host pod
+        getent group systemd-journal >/dev/null && usermod -a -G systemd-journal kube-pet || :
+
         # allow managing the network without sudo
         setcap cap_net_admin+ep "$(readlink /usr/bin/kube-pet-node)"
     ;;
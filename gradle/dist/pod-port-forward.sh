@@ -0,0 +1,30 @@
+#!/bin/sh -eu
+# Connects stdio to a loopback port inside a pod's network namespace, for `kubectl port-forward`.
+# sudo lets kube-pet run this with any arguments, so only a numeric PID and port get through.
+
+if [ "$#" -ne 2 ]
+then
+  echo "Usage: pod-port-forward.sh <pid> <port>" >&2
+  exit 2
+fi
+TargetPid="$1"
+TargetPort="$2"
+
+case "${TargetPid}" in
+  ''|*[!0-9]*|0*)
+    echo "Given PID '${TargetPid}' isn't a process ID" >&2
+    exit 2;;
+esac
+
+case "${TargetPort}" in
+  ''|*[!0-9]*|0*)
+    echo "Given port '${TargetPort}' isn't a port number" >&2
+    exit 2;;
+esac
+if [ "${#TargetPort}" -gt 5 ] || [ "${TargetPort}" -gt 65535 ]
+then
+  echo "Given port '${TargetPort}' isn't a port number" >&2
+  exit 2
+fi
+
+exec /usr/bin/nsenter --target "${TargetPid}" --net -- socat - "TCP:localhost:${TargetPort}"
@@ -20,5 +20,8 @@ if [ $1 -eq 1 ] && [ -x /usr/bin/systemctl ] ; then
   /usr/bin/systemctl enable --now kube-pet-node.service >/dev/null 2>&1 || :
 fi
 
+# allow reading the kernel log for the host pod
+getent group systemd-journal >/dev/null && usermod -a -G systemd-journal kube-pet || :
+
 # allow managing the network without sudo
 setcap cap_net_admin+ep "$(readlink /usr/bin/kube-pet-node)"
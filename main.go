@@ -7,16 +7,22 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/danopia/kube-pet-node/controller"
+	"github.com/danopia/kube-pet-node/controllers/kubeapi"
+	"github.com/danopia/kube-pet-node/controllers/nodeshutdown"
 	"github.com/danopia/kube-pet-node/controllers/pods"
 	"github.com/danopia/kube-pet-node/controllers/selfprovision"
 	"github.com/danopia/kube-pet-node/pkg/podman"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	certutil "k8s.io/client-go/util/cert"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 )
@@ -25,15 +31,46 @@ func main() {
 
 	var nameFlag = flag.String("hostname", "", "name to use for the Kubernetes node, will get prefixed with 'pet-'")
 	var kubeconfFlag = flag.String("kubeconfig-path", "node-kubeconfig.yaml", "path to client config with a system:node clusterrolebinding")
+	var bootstrapKubeconfFlag = flag.String("bootstrap-kubeconfig-path", "", "path to a bootstrap token kubeconfig, used to request a client certificate when --kubeconfig-path doesn't exist yet")
 	var podmanSockFlag = flag.String("podman-socket", "tcp:127.0.0.1:8410", "podman socket location, either 'tcp:' or 'unix:' prefix")
 	var vpnIfaceFlag = flag.String("vpn-iface", "wg-gke", "network interface which the other cluster nodes and pods are available on")
 	var cniNetFlag = flag.String("cni-net", "kube-pet-net", "CNI network which provides local pods with networking and addresses")
 	var maxPodsFlag = flag.Int("max-pods", 25, "number of pods this node should support. 0 effectively disables scheduling")
+	var manifestPathFlag = flag.String("pod-manifest-path", "", "directory of static pod manifests to run even without the API server, blank to disable")
+	var hostExecFlag = flag.Bool("enable-host-exec", false, "allow 'kubectl exec' into the host pod to run commands as root on this machine")
+	var rootlessFlag = flag.Bool("rootless", false, "run pods with a rootless podman: no pod IPs, no firewall, and the node gets tainted")
+	var rootlessNetFlag = flag.String("rootless-net", "slirp4netns", "network mode for rootless pods, either 'slirp4netns' or 'pasta'")
+	var keyTypeFlag = flag.String("key-type", kubeapi.KeyTypeECDSA, "type of private key to generate for our certificates, either 'ecdsa' or 'rsa'")
+	var clientCAFlag = flag.String("client-ca-file", "", "CA bundle for verifying kubelet API client certificates, defaults to the kubeconfig's cluster CA")
+	var anonymousAuthFlag = flag.Bool("anonymous-auth", false, "let kubelet API requests without credentials through as system:anonymous, still subject to authorization")
+	var shutdownGraceFlag = flag.Duration("shutdown-grace-period", 0, "when the machine shuts down, delay it this long with a systemd inhibitor lock while stopping pods. 0 leaves pods running")
 	_ = flag.String("controllers", "firewall,podman", "which features to run")
 	flag.Parse()
 
+	// podman would only complain about this once a pod gets created
+	if *rootlessNetFlag != "slirp4netns" && *rootlessNetFlag != "pasta" {
+		log.Fatalln("Unknown --rootless-net", *rootlessNetFlag, "- try 'slirp4netns' or 'pasta'")
+	}
+
 	var nodeName string
 
+	// with a bootstrap kubeconfig, we make our own node kubeconfig with a rotating certificate
+	var clientCerts *kubeapi.ClientCertManager
+	if *bootstrapKubeconfFlag != "" {
+		if *nameFlag == "" {
+			log.Fatalln("Hostname is required when bootstrapping, try passing --hostname=<xyz>")
+		}
+		certManager, err := kubeapi.NewClientCertManager("pet-"+*nameFlag, *keyTypeFlag)
+		if err != nil {
+			panic(err)
+		}
+		if managed, err := certManager.Bootstrap(context.Background(), *bootstrapKubeconfFlag, *kubeconfFlag); err != nil {
+			panic(err)
+		} else if managed {
+			clientCerts = certManager
+		}
+	}
+
 	// read the kubeconfig ourselves to see what our user is called in it
 	kubeConfig, err := (&clientcmd.ClientConfigLoadingRules{ExplicitPath: *kubeconfFlag}).Load()
 	if err != nil {
@@ -51,11 +88,11 @@ func main() {
 	}
 
 	// look up our VPN information
-	nodeIP, err := fetchNodeAddressFromInterface(*vpnIfaceFlag)
+	nodeIPs, err := fetchNodeAddressesFromInterface(*vpnIfaceFlag)
 	if err != nil {
 		log.Println("WARN: failed to find Node IP from", *vpnIfaceFlag, ":", err)
 	} else {
-		log.Println("Discovered node IP:", nodeIP)
+		log.Println("Discovered node IPs:", nodeIPs)
 	}
 
 	// unix:/run/user/1000/podman/podman.sock
@@ -74,60 +111,133 @@ func main() {
 		panic(err)
 	}
 
+	// the API server's client certificate is usually signed by the cluster CA
+	apiAuth := kubeapi.AuthOptions{Anonymous: *anonymousAuthFlag}
+	if *clientCAFlag != "" {
+		if apiAuth.ClientCAs, err = certutil.NewPool(*clientCAFlag); err != nil {
+			panic(err)
+		}
+	} else if len(config.TLSClientConfig.CAData) > 0 {
+		if apiAuth.ClientCAs, err = certutil.NewPoolFromBytes(config.TLSClientConfig.CAData); err != nil {
+			panic(err)
+		}
+	} else if config.TLSClientConfig.CAFile != "" {
+		if apiAuth.ClientCAs, err = certutil.NewPool(config.TLSClientConfig.CAFile); err != nil {
+			panic(err)
+		}
+	}
+
+	// keep the clientset working as our client certificate rotates
+	if clientCerts != nil {
+		if err := clientCerts.WrapConfig(config); err != nil {
+			panic(err)
+		}
+	}
+
 	// create the clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		panic(err)
 	}
+	// for the fields which our typed client is too old to know about
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		panic(err)
+	}
+
+	// hold off the machine's shutdown so that we can stop our pods first
+	var nodeShutdown *nodeshutdown.NodeShutdown
+	var shuttingDown <-chan struct{}
+	if *shutdownGraceFlag > 0 {
+		if nodeShutdown, err = nodeshutdown.NewNodeShutdown(*shutdownGraceFlag); err != nil {
+			log.Println("WARN: Pods won't be stopped when the machine shuts down:", err)
+		} else {
+			shuttingDown = nodeShutdown.ShuttingDown()
+		}
+	}
 
 	// actually set up our lifespan now that we've loaded enough deps
 	ctx, cancel := context.WithCancel(context.Background())
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		<-sig
+		select {
+		case <-sig:
+		case <-shuttingDown:
+		}
 		cancel()
 		<-sig
 		log.Fatalln("WARN: Received second signal -- bailing without shutdown!")
 	}()
+	if clientCerts != nil {
+		go clientCerts.Run(ctx, clientset)
+	}
+	if nodeShutdown != nil {
+		go nodeShutdown.Run(ctx)
+	}
 
 	// sync our local pod info sources
-	podManager, err := pods.NewPodManager(podman, podStorage)
+	podManager, err := pods.NewPodManager(podman, podStorage, *rootlessFlag)
 	if err != nil {
 		panic(err)
 	}
 
 	// discover CIDRs that pods can use
 	var podNets []net.IPNet
+	var rootlessNet string
 	maxPods := *maxPodsFlag
-	cniNetworks, err := podman.NetworkInspect(ctx, *cniNetFlag)
-	if err != nil {
-		log.Println("WARN: failed to read CNI", *cniNetFlag, ":", err)
-	}
-	for _, cniNetwork := range cniNetworks {
-		for _, plugin := range cniNetwork.Plugins {
-			if plugin.Ipam != nil {
-				// log.Printf("%+v", plugin.Ipam)
-				if plugin.Ipam.Subnet != "" {
-					_, ipn, err := net.ParseCIDR(plugin.Ipam.Subnet)
-					if err != nil {
-						panic(err)
+	if *rootlessFlag {
+		// rootless pods don't get IPs, so there's no CNI to look at
+		rootlessNet = *rootlessNetFlag
+		log.Println("Running rootless, pods will use", rootlessNet, "networking")
+
+	} else {
+		cniNetworks, err := podman.NetworkInspect(ctx, *cniNetFlag)
+		if err != nil {
+			log.Println("WARN: failed to read CNI", *cniNetFlag, ":", err)
+		}
+		for _, cniNetwork := range cniNetworks {
+			for _, plugin := range cniNetwork.Plugins {
+				if plugin.Ipam != nil {
+					// log.Printf("%+v", plugin.Ipam)
+					// host-local takes either one subnet, or range sets which can be dual-stack
+					subnets := []string{plugin.Ipam.Subnet}
+					for _, rangeSet := range plugin.Ipam.Ranges {
+						for _, ipamRange := range rangeSet {
+							subnets = append(subnets, ipamRange.Subnet)
+						}
+					}
+					found := false
+					for _, subnet := range subnets {
+						if subnet == "" {
+							continue
+						}
+						found = true
+						_, ipn, err := net.ParseCIDR(subnet)
+						if err != nil {
+							panic(err)
+						}
+						podNets = appendPodNet(podNets, *ipn)
+					}
+					if !found {
+						log.Println("TODO: cni plugin IPAM without a Subnet")
 					}
-					podNets = append(podNets, *ipn)
-				} else {
-					log.Println("TODO: cni plugin IPAM without a Subnet")
 				}
 			}
 		}
-	}
-	if len(podNets) < 1 && maxPods > 0 {
-		log.Println("WARN: I couldn't discover any pod networks! I'm going to refuse to run any pods.")
-		maxPods = 0
+		// IPv4 stays the primary pod network when there's both
+		sort.SliceStable(podNets, func(i, j int) bool {
+			return podNets[i].IP.To4() != nil && podNets[j].IP.To4() == nil
+		})
+		if len(podNets) < 1 && maxPods > 0 {
+			log.Println("WARN: I couldn't discover any pod networks! I'm going to refuse to run any pods.")
+			maxPods = 0
+		}
 	}
 	log.Println("Pod networks:", podNets)
 
 	// construct the node
-	petNode, err := controller.NewPetNode(ctx, nodeName, podManager, clientset, maxPods, *vpnIfaceFlag, nodeIP, podNets, *cniNetFlag)
+	petNode, err := controller.NewPetNode(ctx, nodeName, podManager, clientset, dynamicClient, maxPods, *vpnIfaceFlag, nodeIPs, podNets, *cniNetFlag, *manifestPathFlag, *hostExecFlag, rootlessNet, *keyTypeFlag, apiAuth)
 	if err != nil {
 		panic(err)
 	}
@@ -155,10 +265,32 @@ func main() {
 
 	}
 
+	// the pod runner can also stop on its own, so make sure everything else does too
+	cancel()
+	var gracePeriod time.Duration
+	if nodeShutdown != nil && nodeShutdown.IsShuttingDown() {
+		gracePeriod = nodeShutdown.GracePeriod
+	}
+	petNode.Shutdown(gracePeriod)
+	if nodeShutdown != nil {
+		nodeShutdown.Release()
+	}
+
 	log.Println("exit")
 }
 
-func fetchNodeAddressFromInterface(ifaceName string) (net.IP, error) {
+// each range set can repeat the same subnet
+func appendPodNet(podNets []net.IPNet, podNet net.IPNet) []net.IPNet {
+	for _, existing := range podNets {
+		if existing.String() == podNet.String() {
+			return podNets
+		}
+	}
+	return append(podNets, podNet)
+}
+
+// fetchNodeAddressesFromInterface finds one node address per IP family, IPv4 first
+func fetchNodeAddressesFromInterface(ifaceName string) ([]net.IP, error) {
 	vpnIface, err := net.InterfaceByName(ifaceName)
 	if err != nil {
 		return nil, err
@@ -168,7 +300,8 @@ func fetchNodeAddressFromInterface(ifaceName string) (net.IP, error) {
 		return nil, err
 	}
 
-	// retrieve node address from the VPN info
+	// retrieve node addresses from the VPN info
+	var v4Addr, v6Addr net.IP
 	for _, addr := range vpnAddrs {
 		if net, ok := addr.(*net.IPNet); ok {
 			// log.Println(net, net.IP.IsGlobalUnicast())
@@ -176,7 +309,11 @@ func fetchNodeAddressFromInterface(ifaceName string) (net.IP, error) {
 				ones, bits := net.Mask.Size()
 				if ones == bits {
 					// a single addr, treat as node address
-					return net.IP, nil
+					if net.IP.To4() != nil && v4Addr == nil {
+						v4Addr = net.IP
+					} else if net.IP.To4() == nil && v6Addr == nil {
+						v6Addr = net.IP
+					}
 				} else {
 					log.Println("Skipping CIDR Addr", addr.String(), "on vpn interface")
 				}
@@ -186,5 +323,11 @@ func fetchNodeAddressFromInterface(ifaceName string) (net.IP, error) {
 		}
 	}
 
-	return nil, nil
+	var nodeIPs []net.IP
+	for _, addr := range []net.IP{v4Addr, v6Addr} {
+		if addr != nil {
+			nodeIPs = append(nodeIPs, addr)
+		}
+	}
+	return nodeIPs, nil
 }
@@ -62,3 +62,43 @@ console.log(YAML.stringify({
     namespace: `kube-system`,
   }],
 }));
+
+// for services annotated with kube-pet-node/load-balancer
+// (nodes bootstrapped with a client certificate are system:node:<name> instead of the service account)
+console.log('---')
+console.log(YAML.stringify({
+  apiVersion: 'rbac.authorization.k8s.io/v1beta1',
+  kind: 'ClusterRole',
+  metadata: {
+    name: `kube-pet:load-balancer`,
+  },
+  rules: [{
+    apiGroups: [''],
+    resources: ['services/status'],
+    verbs: ['update'],
+  }],
+}));
+
+console.log('---')
+console.log(YAML.stringify({
+  apiVersion: 'rbac.authorization.k8s.io/v1beta1',
+  kind: 'ClusterRoleBinding',
+  metadata: {
+    name: `kube-pet:load-balancer:${nodeName}`,
+    labels,
+  },
+  roleRef: {
+    apiGroup: `rbac.authorization.k8s.io`,
+    kind: `ClusterRole`,
+    name: `kube-pet:load-balancer`,
+  },
+  subjects: [{
+    kind: `ServiceAccount`,
+    name: `node.${nodeName}`,
+    namespace: `kube-system`,
+  }, {
+    apiGroup: `rbac.authorization.k8s.io`,
+    kind: `User`,
+    name: `system:node:${nodeName}`,
+  }],
+}));
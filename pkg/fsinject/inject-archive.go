@@ -10,9 +10,11 @@ import (
 	"time"
 )
 
-func StartArchiveExtraction(ctx context.Context, dest string) (*ArchiveExtraction, error) {
+// StartArchiveExtraction unpacks into dest, using sudo unless we're already root
+// or the caller knows that dest belongs to us (e.g. rootless podman volumes)
+func StartArchiveExtraction(ctx context.Context, dest string, useSudo bool) (*ArchiveExtraction, error) {
 	var cmd *exec.Cmd
-	if os.Getuid() == 1 {
+	if !useSudo || os.Getuid() == 0 {
 		cmd = exec.CommandContext(ctx, "tar", "-xf", "-", "-C", dest)
 	} else {
 		cmd = exec.CommandContext(ctx, "sudo", "tar", "-xf", "-", "-C", dest)
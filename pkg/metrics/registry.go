@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry holds every metric that's been made, so they can be written out together.
+// It's a small stand-in for the Prometheus client, which we don't vendor.
+type Registry struct {
+	lock       sync.Mutex
+	collectors map[string]collector
+}
+
+// Default is where the New* functions register their metrics
+var Default = &Registry{}
+
+type collector interface {
+	writeTo(mw *Writer)
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.collectors == nil {
+		r.collectors = make(map[string]collector)
+	}
+	if _, ok := r.collectors[name]; ok {
+		panic("metrics: " + name + " registered twice")
+	}
+	r.collectors[name] = c
+}
+
+// WriteAll writes every registered metric, sorted by name
+func (r *Registry) WriteAll(mw *Writer) {
+	r.lock.Lock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	collectors := make([]collector, len(names))
+	sort.Strings(names)
+	for idx, name := range names {
+		collectors[idx] = r.collectors[name]
+	}
+	r.lock.Unlock()
+
+	for _, c := range collectors {
+		c.writeTo(mw)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	mw := NewWriter(w)
+	r.WriteAll(mw)
+	mw.Flush()
+}
+
+// vec is the shared part of every metric: a family with a set of labelled series
+type vec struct {
+	name       string
+	help       string
+	labelNames []string
+
+	lock   sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels Labels
+	value  float64
+
+	// only for histograms
+	buckets []uint64
+	count   uint64
+}
+
+func (v *vec) get(labelValues []string) *series {
+	if len(labelValues) != len(v.labelNames) {
+		panic("metrics: " + v.name + " got the wrong number of labels")
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		labels := make(Labels, len(labelValues))
+		for idx, name := range v.labelNames {
+			labels[name] = labelValues[idx]
+		}
+		s = &series{labels: labels}
+		if v.series == nil {
+			v.series = make(map[string]*series)
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series in a stable order, the caller has to hold the lock
+func (v *vec) sorted() []*series {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := make([]*series, len(keys))
+	for idx, key := range keys {
+		list[idx] = v.series[key]
+	}
+	return list
+}
+
+type CounterVec struct {
+	vec
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	cv := &CounterVec{vec{name: name, help: help, labelNames: labelNames}}
+	Default.register(name, cv)
+	return cv
+}
+
+func (cv *CounterVec) Inc(labelValues ...string) {
+	cv.Add(1, labelValues...)
+}
+
+func (cv *CounterVec) Add(value float64, labelValues ...string) {
+	cv.lock.Lock()
+	defer cv.lock.Unlock()
+	cv.get(labelValues).value += value
+}
+
+func (cv *CounterVec) writeTo(mw *Writer) {
+	cv.lock.Lock()
+	defer cv.lock.Unlock()
+	mw.Family(cv.name, cv.help, Counter)
+	for _, s := range cv.sorted() {
+		mw.Sample(cv.name, s.labels, s.value)
+	}
+}
+
+type GaugeVec struct {
+	vec
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	gv := &GaugeVec{vec{name: name, help: help, labelNames: labelNames}}
+	Default.register(name, gv)
+	return gv
+}
+
+func (gv *GaugeVec) Set(value float64, labelValues ...string) {
+	gv.lock.Lock()
+	defer gv.lock.Unlock()
+	gv.get(labelValues).value = value
+}
+
+// Reset forgets every series, for gauges where the label set changes over time
+func (gv *GaugeVec) Reset() {
+	gv.lock.Lock()
+	defer gv.lock.Unlock()
+	gv.series = nil
+}
+
+func (gv *GaugeVec) writeTo(mw *Writer) {
+	gv.lock.Lock()
+	defer gv.lock.Unlock()
+	mw.Family(gv.name, gv.help, Gauge)
+	for _, s := range gv.sorted() {
+		mw.Sample(gv.name, s.labels, s.value)
+	}
+}
+
+// DefBuckets suit things which take around a second, the same as the Prometheus client's
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// SlowBuckets suit things which take minutes, like pulling images
+var SlowBuckets = []float64{.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+type HistogramVec struct {
+	vec
+	upperBounds []float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	hv := &HistogramVec{vec{name: name, help: help, labelNames: labelNames}, buckets}
+	Default.register(name, hv)
+	return hv
+}
+
+func (hv *HistogramVec) Observe(value float64, labelValues ...string) {
+	hv.lock.Lock()
+	defer hv.lock.Unlock()
+	s := hv.get(labelValues)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(hv.upperBounds))
+	}
+	for idx, bound := range hv.upperBounds {
+		if value <= bound {
+			s.buckets[idx]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+func (hv *HistogramVec) writeTo(mw *Writer) {
+	hv.lock.Lock()
+	defer hv.lock.Unlock()
+	mw.Family(hv.name, hv.help, Histogram)
+	for _, s := range hv.sorted() {
+		for idx, bound := range hv.upperBounds {
+			mw.Sample(hv.name+"_bucket", withLe(s.labels, formatValue(bound)), float64(s.buckets[idx]))
+		}
+		mw.Sample(hv.name+"_bucket", withLe(s.labels, "+Inf"), float64(s.count))
+		mw.Sample(hv.name+"_sum", s.labels, s.value)
+		mw.Sample(hv.name+"_count", s.labels, float64(s.count))
+	}
+}
+
+func withLe(labels Labels, le string) Labels {
+	extended := make(Labels, len(labels)+1)
+	for name, value := range labels {
+		extended[name] = value
+	}
+	extended["le"] = le
+	return extended
+}
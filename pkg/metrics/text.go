@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentType is what Prometheus expects from a text-format scrape
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metric types
+const (
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
+)
+
+type Labels map[string]string
+
+// Writer produces the Prometheus text exposition format.
+// Every sample of a family has to be written right after its Family call.
+type Writer struct {
+	out *bufio.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{out: bufio.NewWriter(w)}
+}
+
+func (mw *Writer) Family(name, help, metricType string) {
+	mw.write("# HELP " + name + " " + helpEscaper.Replace(help) + "\n")
+	mw.write("# TYPE " + name + " " + metricType + "\n")
+}
+
+func (mw *Writer) Sample(name string, labels Labels, value float64) {
+	mw.write(name + formatLabels(labels) + " " + formatValue(value) + "\n")
+}
+
+// SampleAt includes an explicit timestamp, for values which were collected a while ago
+func (mw *Writer) SampleAt(name string, labels Labels, value float64, stamp time.Time) {
+	mw.write(name + formatLabels(labels) + " " + formatValue(value) + " " + strconv.FormatInt(stamp.UnixNano()/int64(time.Millisecond), 10) + "\n")
+}
+
+// Flush writes out anything buffered and returns the first error seen
+func (mw *Writer) Flush() error {
+	if mw.err == nil {
+		mw.err = mw.out.Flush()
+	}
+	return mw.err
+}
+
+func (mw *Writer) write(line string) {
+	if mw.err == nil {
+		_, mw.err = mw.out.WriteString(line)
+	}
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for idx, name := range names {
+		if idx > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name + `="` + valueEscaper.Replace(labels[name]) + `"`)
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,77 @@
+package podman
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type ContainerAttachOptions struct {
+	Stdin  bool
+	Stdout bool
+	Stderr bool
+	// Logs replays the container's existing output before attaching
+	Logs bool
+}
+
+// ContainerAttach(ctx context.Context, nameOrID string, options AttachOptions) error
+// Works like ExecSession.Start: the output is multiplexed unless the container has a TTY,
+// and closing the output ends the whole attach session
+func (pc *PodmanClient) ContainerAttach(ctx context.Context, nameOrId string, options *ContainerAttachOptions) (io.Writer, io.ReadCloser, error) {
+	encoded, err := UrlEncoded(nameOrId)
+	if err != nil {
+		return nil, nil, err
+	}
+	flags := "?stream=true"
+	if options.Stdin {
+		flags += "&stdin=true"
+	}
+	if options.Stdout {
+		flags += "&stdout=true"
+	}
+	if options.Stderr {
+		flags += "&stderr=true"
+	}
+	if options.Logs {
+		flags += "&logs=true"
+	}
+
+	path := "/libpod/containers/" + encoded + "/attach" + flags
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://podman/v1.0.0"+path, bytes.NewBuffer([]byte{}))
+	req.Header.Set("connection", "upgrade")
+	req.Header.Set("upgrade", "tcp")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := pc.performRequest(req, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Println("Attach resp:", resp)
+
+	if input, ok := resp.Body.(io.Writer); ok {
+		return input, resp.Body, nil
+	} else {
+		return nil, resp.Body, nil
+	}
+}
+
+// ContainerResize(ctx context.Context, nameOrID string, options ResizeTTYOptions) error
+func (pc *PodmanClient) ContainerResize(ctx context.Context, nameOrId string, width, height uint16) error {
+	encoded, err := UrlEncoded(nameOrId)
+	if err != nil {
+		return err
+	}
+	flags := "?w=" + strconv.Itoa(int(width)) + "&h=" + strconv.Itoa(int(height))
+
+	// the response body isn't consistent between versions, and we don't need it anyway
+	var out interface{}
+	if err := pc.performPost(ctx, "/libpod/containers/"+encoded+"/resize"+flags, nil, &out); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -3,11 +3,10 @@ package podman
 import (
 	"context"
 	"io"
+	"net/url"
 	"time"
 )
 
-// ContainerAttach(ctx context.Context, nameOrID string, options AttachOptions) error
-
 // ContainerCheckpoint(ctx context.Context, namesOrIds []string, options CheckpointOptions) ([]*CheckpointReport, error)
 
 // ContainerCleanup(ctx context.Context, namesOrIds []string, options ContainerCleanupOptions) ([]*ContainerCleanupReport, error)
@@ -209,10 +208,10 @@ func (pc *PodmanClient) ContainerLogs(ctx context.Context, nameOrId string, opti
 		flags += "&timestamps=true"
 	}
 	if options.Since != "" {
-		flags += "&since=" + options.Since
+		flags += "&since=" + url.QueryEscape(options.Since)
 	}
 	if options.Until != "" {
-		flags += "&until=" + options.Until
+		flags += "&until=" + url.QueryEscape(options.Until)
 	}
 	if options.Tail != "" {
 		flags += "&tail=" + options.Tail
@@ -244,6 +243,14 @@ func (pc *PodmanClient) ContainerLogs(ctx context.Context, nameOrId string, opti
 // ContainerRunlabel(ctx context.Context, label string, image string, args []string, opts ContainerRunlabelOptions) error
 
 // ContainerStart(ctx context.Context, namesOrIds []string, options ContainerStartOptions) ([]*ContainerStartReport, error)
+func (pc *PodmanClient) ContainerStart(ctx context.Context, nameOrId string) error {
+	encoded, err := UrlEncoded(nameOrId)
+	if err != nil {
+		return err
+	}
+
+	return pc.performPost(ctx, "/libpod/containers/"+encoded+"/start", nil, nil)
+}
 
 // ContainerStats(ctx context.Context, namesOrIds []string, options ContainerStatsOptions) error
 // TODO: support namesOrIds and stream=true
@@ -0,0 +1,39 @@
+package podman
+
+import (
+	"context"
+	"strconv"
+)
+
+// GenerateKube(ctx context.Context, nameOrIDs []string, options GenerateKubeOptions) (*GenerateKubeReport, error)
+
+// GenerateSystemd(ctx context.Context, nameOrID string, options GenerateSystemdOptions) (*GenerateSystemdReport, error)
+type GenerateSystemdOptions struct {
+	// New creates new containers instead of starting existing ones
+	New bool
+	// RestartPolicy is the systemd restart policy, e.g. "on-failure" or "always"
+	RestartPolicy string
+	// StopTimeout is how many seconds to wait before killing the containers
+	StopTimeout *uint
+}
+
+// GenerateSystemd returns the contents of each unit file, keyed by unit name without the .service suffix
+func (pc *PodmanClient) GenerateSystemd(ctx context.Context, nameOrId string, options *GenerateSystemdOptions) (map[string]string, error) {
+	encoded, err := UrlEncoded(nameOrId)
+	if err != nil {
+		return nil, err
+	}
+	flags := "?useName=true"
+	if options.New {
+		flags += "&new=true"
+	}
+	if options.RestartPolicy != "" {
+		flags += "&restartPolicy=" + options.RestartPolicy
+	}
+	if options.StopTimeout != nil {
+		flags += "&time=" + strconv.FormatUint(uint64(*options.StopTimeout), 10)
+	}
+
+	var out map[string]string
+	return out, pc.performGet(ctx, "/libpod/generate/"+encoded+"/systemd"+flags, &out)
+}
@@ -2,6 +2,8 @@ package podman
 
 import (
 	"context"
+	"math"
+	"strconv"
 	"time"
 )
 
@@ -136,14 +138,24 @@ func (pc *PodmanClient) PodStats(ctx context.Context) ([]*PodStatsReport, error)
 
 // PodStop(ctx context.Context, namesOrIds []string, options PodStopOptions) ([]*PodStopReport, error)
 func (pc *PodmanClient) PodStop(ctx context.Context, nameOrId string) (*PodActionReport, error) {
-	// TODO: ?t= timeout secs
+	return pc.podStop(ctx, nameOrId, "")
+}
+
+// PodStopWithTimeout gives each container timeout to exit before it gets killed,
+// instead of podman's default of 10 seconds
+func (pc *PodmanClient) PodStopWithTimeout(ctx context.Context, nameOrId string, timeout time.Duration) (*PodActionReport, error) {
+	seconds := int(math.Ceil(timeout.Seconds()))
+	return pc.podStop(ctx, nameOrId, "?t="+strconv.Itoa(seconds))
+}
+
+func (pc *PodmanClient) podStop(ctx context.Context, nameOrId string, flags string) (*PodActionReport, error) {
 	encoded, err := UrlEncoded(nameOrId)
 	if err != nil {
 		return nil, err
 	}
 
 	var out PodActionReport
-	if err := pc.performPost(ctx, "/libpod/pods/"+encoded+"/stop", nil, &out); err != nil {
+	if err := pc.performPost(ctx, "/libpod/pods/"+encoded+"/stop"+flags, nil, &out); err != nil {
 		if err, ok := err.(*ApiError); ok {
 			if err.Status == 304 {
 				return &PodActionReport{
@@ -2,6 +2,7 @@ package podman
 
 import (
 	"context"
+	"time"
 )
 
 // AutoUpdate(ctx context.Context, options AutoUpdateOptions) (*AutoUpdateReport, []error)
@@ -19,6 +20,35 @@ import (
 // HealthCheckRun(ctx context.Context, nameOrID string, options HealthCheckOptions) (*define.HealthCheckResults, error)
 
 // Info(ctx context.Context) (*define.Info, error)
+// Only the storage details are here so far
+func (pc *PodmanClient) Info(ctx context.Context) (*Info, error) {
+	var out Info
+	return &out, pc.performGet(ctx, "/libpod/info", &out)
+}
+
+type Info struct {
+	Store *StoreInfo `json:"store"`
+}
+type StoreInfo struct {
+	ConfigFile      string                 `json:"configFile"`
+	ContainerStore  ContainerStore         `json:"containerStore"`
+	GraphDriverName string                 `json:"graphDriverName"`
+	GraphOptions    map[string]interface{} `json:"graphOptions"`
+	GraphRoot       string                 `json:"graphRoot"`
+	GraphStatus     map[string]string      `json:"graphStatus"`
+	ImageStore      ImageStore             `json:"imageStore"`
+	RunRoot         string                 `json:"runRoot"`
+	VolumePath      string                 `json:"volumePath"`
+}
+type ContainerStore struct {
+	Number  int `json:"number"`
+	Paused  int `json:"paused"`
+	Running int `json:"running"`
+	Stopped int `json:"stopped"`
+}
+type ImageStore struct {
+	Number int `json:"number"`
+}
 
 // PlayKube(ctx context.Context, path string, opts PlayKubeOptions) (*PlayKubeReport, error)
 
@@ -27,6 +57,44 @@ import (
 // Shutdown(ctx context.Context)
 
 // SystemDf(ctx context.Context, options SystemDfOptions) (*SystemDfReport, error)
+// This walks the filesystem for every container and volume, so it's not fast
+func (pc *PodmanClient) SystemDf(ctx context.Context) (*SystemDfReport, error) {
+	var out SystemDfReport
+	return &out, pc.performGet(ctx, "/libpod/system/df", &out)
+}
+
+type SystemDfReport struct {
+	Images     []*SystemDfImageReport
+	Containers []*SystemDfContainerReport
+	Volumes    []*SystemDfVolumeReport
+}
+type SystemDfImageReport struct {
+	Repository string
+	Tag        string
+	ImageID    string
+	Created    time.Time
+	Size       int64
+	SharedSize int64
+	UniqueSize int64
+	Containers int
+}
+type SystemDfContainerReport struct {
+	ContainerID  string
+	Image        string
+	Command      []string
+	LocalVolumes int
+	Size         int64
+	RWSize       int64
+	Created      time.Time
+	Status       string
+	Names        string
+}
+type SystemDfVolumeReport struct {
+	VolumeName      string
+	Links           int
+	Size            int64
+	ReclaimableSize int64
+}
 
 // Unshare(ctx context.Context, args []string) error
 
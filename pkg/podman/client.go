@@ -8,6 +8,8 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type PodmanClient struct {
@@ -28,12 +30,16 @@ func NewPodmanClient(proto, socket string) *PodmanClient {
 }
 
 func (pc *PodmanClient) performRequest(req *http.Request, path string) (*http.Response, error) {
+	startTime := time.Now()
 	resp, err := pc.http.Do(req)
+	requestDuration.Observe(time.Since(startTime).Seconds(), req.Method, pathTemplate(path))
 	if err != nil {
 		log.Println(req.Method, path, err.Error())
+		requestsTotal.Inc(req.Method, pathTemplate(path), "error")
 		return resp, err
 	} else {
 		log.Println(req.Method, path, resp.Status)
+		requestsTotal.Inc(req.Method, pathTemplate(path), strconv.Itoa(resp.StatusCode))
 	}
 
 	// check for happy path, return on the spot
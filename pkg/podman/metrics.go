@@ -0,0 +1,36 @@
+package podman
+
+import (
+	"strings"
+
+	"github.com/danopia/kube-pet-node/pkg/metrics"
+)
+
+var (
+	requestsTotal   = metrics.NewCounterVec("kube_pet_podman_requests_total", "Podman API calls, by path and response status.", "method", "path", "status")
+	requestDuration = metrics.NewHistogramVec("kube_pet_podman_request_duration_seconds", "How long Podman took to start responding to API calls.", metrics.DefBuckets, "method", "path")
+)
+
+// the parts of the API which have a resource's name after them,
+// as opposed to e.g. /libpod/system/df
+var namedResources = map[string]bool{
+	"containers": true, "pods": true, "images": true, "volumes": true, "networks": true, "exec": true, "generate": true,
+}
+
+// these come right after a resource type, but aren't a resource's name
+var collectionActions = map[string]bool{
+	"json": true, "create": true, "stats": true, "prune": true, "pull": true, "ps": true,
+}
+
+// pathTemplate takes the names out of a path, so that every container doesn't get its own metrics
+func pathTemplate(path string) string {
+	if idx := strings.IndexByte(path, '?'); idx >= 0 {
+		path = path[:idx]
+	}
+	parts := strings.Split(path, "/")
+	// e.g. ["", "libpod", "containers", "ns_pod_con", "json"]
+	if len(parts) >= 4 && parts[1] == "libpod" && namedResources[parts[2]] && !collectionActions[parts[3]] {
+		parts[3] = "{name}"
+	}
+	return strings.Join(parts, "/")
+}